@@ -0,0 +1,1161 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/editor"
+	"github.com/matouschdavid/Clipboard-queue/pkg/queue"
+	"github.com/matouschdavid/Clipboard-queue/pkg/snippet"
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+	"github.com/matouschdavid/Clipboard-queue/pkg/transfer"
+	"github.com/matouschdavid/Clipboard-queue/pkg/transform"
+	"github.com/matouschdavid/Clipboard-queue/pkg/tui"
+)
+
+// command is a CLI subcommand operating on the persisted queue.
+type command struct {
+	usage string
+	help  string
+	run   func(mgr *queue.Manager, args []string) error
+}
+
+var commands = map[string]command{
+	"list": {
+		usage: "list [-since t] [-until t]",
+		help:  "Show the queue with the index of every item, or the items copied in a time range",
+		run:   cmdList,
+	},
+	"queues": {
+		usage: "queues",
+		help:  "List the queues in a bolt database, marking the selected one",
+		run:   cmdQueues,
+	},
+	"insert": {
+		usage: "insert <index> <text>",
+		help:  "Insert text so that it ends up at index",
+		run:   cmdInsert,
+	},
+	"delete": {
+		usage: "delete <index>",
+		help:  "Remove the item at index",
+		run:   cmdDelete,
+	},
+	"replace": {
+		usage: "replace <index> <text>",
+		help:  "Overwrite the item at index",
+		run:   cmdReplace,
+	},
+	"move": {
+		usage: "move <from> <to>",
+		help:  "Move the item at from to index to",
+		run:   cmdMove,
+	},
+	"swap": {
+		usage: "swap <i> <j>",
+		help:  "Exchange two items",
+		run:   cmdSwap,
+	},
+	"edit": {
+		usage: "edit <index|id>",
+		help:  "Edit an item in $VISUAL / $EDITOR",
+		run:   cmdEdit,
+	},
+	"mode": {
+		usage: "mode [queue|stack|priority|shuffle]",
+		help:  "Show or set the order items are pasted in",
+		run:   cmdMode,
+	},
+	"modes": {
+		usage: "modes <mode,...>",
+		help:  "Set the modes Cmd+M switches between (default queue,stack)",
+		run:   cmdModes,
+	},
+	"priority": {
+		usage: "priority <index|id> <n>",
+		help:  "Set an item's priority; higher is pasted first in priority mode",
+		run:   cmdPriority,
+	},
+	"pin": {
+		usage: "pin [index|id]",
+		help:  "Keep an item across clears; without arguments, list pinned items",
+		run:   cmdPin,
+	},
+	"unpin": {
+		usage: "unpin <index|id>",
+		help:  "Stop keeping a pinned item",
+		run:   cmdUnpin,
+	},
+	"pin-position": {
+		usage: "pin-position <first|last>",
+		help:  "Paste pinned items before or after all others (default first)",
+		run:   cmdPinPosition,
+	},
+	"cycle": {
+		usage: "cycle <on|off>",
+		help:  "Keep pasted items and start over at the end instead of draining",
+		run:   cmdCycle,
+	},
+	"repeat": {
+		usage: "repeat <index|id> <times>",
+		help:  "Paste an item several times before moving on",
+		run:   cmdRepeat,
+	},
+	"split": {
+		usage: "split <index|id> [delim]",
+		help:  "Split an item into several in place (default delimiter: newline)",
+		run:   cmdSplit,
+	},
+	"split-mode": {
+		usage: "split-mode <delim|off>",
+		help:  "Split captured copies on newline, nul, comma, tab or re:<pattern>",
+		run:   cmdSplitMode,
+	},
+	"join": {
+		usage: "join [-with sep] [index|id...]",
+		help:  "Join the given items, or the whole queue, into one item",
+		run:   cmdJoin,
+	},
+	"join-with": {
+		usage: "join-with <separator|template>",
+		help:  `Set how items are joined, e.g. ", " or "\n\n" (default "\n")`,
+		run:   cmdJoinWith,
+	},
+	"transform": {
+		usage: "transform [capture|paste <name,...|off>]",
+		help:  "Transform items when captured or pasted; without arguments, show the settings",
+		run:   cmdTransform,
+	},
+	"template": {
+		usage: "template <template|off>",
+		help:  `Render items before pasting, e.g. "VALUES ('{{.Text}}')"`,
+		run:   cmdTemplate,
+	},
+	"meta": {
+		usage: "meta <index|id> [key=value...]",
+		help:  "Show or set an item's metadata (key= removes a key)",
+		run:   cmdMeta,
+	},
+	"generate": {
+		usage: "generate <pattern|off>",
+		help:  "Paste generated values, e.g. user-{n:001..100}, {date} or {uuid}",
+		run:   cmdGenerate,
+	},
+	"snippet": {
+		usage: "snippet add <name> <text|-> | list | rm <name> | group <name> <snippet...> | use <name...>",
+		help:  "Manage the snippet library and queue snippets or groups of them",
+		run:   cmdSnippet,
+	},
+	"push": {
+		usage: "push [-split delim] <text...|->",
+		help:  "Queue the arguments, or stdin split by newline, nul, comma, tab or re:<pattern>",
+		run:   cmdPush,
+	},
+	"import": {
+		usage: "import [-format f] [-column n] [-header] [-replace] [-history] <file|->",
+		help:  "Queue items, or add them to the history, from a lines, nul, json, ndjson or csv file",
+		run:   cmdImport,
+	},
+	"export": {
+		usage: "export [-format f] [-o file] [-history]",
+		help:  "Write the queue or the history as lines, nul, json, ndjson or csv",
+		run:   cmdExport,
+	},
+	"history": {
+		usage: "history [clear]",
+		help:  "Show the items pasted most recently, or forget them",
+		run:   cmdHistory,
+	},
+	"backup": {
+		usage: "backup list | now",
+		help:  "List the snapshots kept of the queue, or take one",
+		run:   cmdBackup,
+	},
+	"restore": {
+		usage: "restore <snapshot>",
+		help:  "Replace the queue with a snapshot, by name or unique prefix",
+		run:   cmdRestore,
+	},
+	"storage": {
+		usage: "storage [json|journal|bolt|memory]",
+		help:  "Show how the queue is stored, or move it to another backend",
+		run:   cmdStorage,
+	},
+	"tui": {
+		usage: "tui",
+		help:  "Browse and edit the queue in a full-screen terminal UI",
+		run:   cmdTUI,
+	},
+}
+
+// errUsage signals that a command was called with the wrong arguments.
+var errUsage = errors.New("invalid arguments")
+
+// newManager opens the storage cfg selects. onRecover is told if a damaged
+// state file had to be recovered.
+func newManager(cfg *storage.Config, onRecover func(storage.Recovery)) *queue.Manager {
+	dir, err := storage.DefaultDir()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get storage path: %v\n", err)
+		os.Exit(1)
+	}
+	s := cfg.Open(dir)
+	if js, ok := storage.Unwrap(s).(*storage.JSONStorage); ok {
+		js.OnRecover = onRecover
+	}
+	mgr := queue.NewManager(s, &queue.SystemClipboard{})
+	if snapshots := cfg.Snapshots(dir); snapshots != nil {
+		mgr.UseSnapshots(snapshots)
+	}
+	return mgr
+}
+
+// stateFile, set by --state-file, overrides where the queue is kept.
+var stateFile string
+
+// loadConfig moves the files of older versions out of ~/.cbq if needed,
+// then reads the config from its default path, applying --state-file. It
+// exits if it cannot.
+func loadConfig() *storage.Config {
+	legacy, moved, err := storage.MoveLegacyFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to move the files in %s to their new place: %v\n(move them by hand, or set %s=%s to keep using it)\n",
+			legacy, err, storage.HomeEnv, legacy)
+		os.Exit(1)
+	}
+	if len(moved) > 0 {
+		dirs, _ := storage.DefaultDirs()
+		fmt.Fprintf(os.Stderr, "cbq: moved %d files from %s to %s (config in %s, log in %s)\n",
+			len(moved), legacy, dirs.Data, dirs.Config, dirs.State)
+	}
+
+	path, err := storage.DefaultConfigPath()
+	if err == nil {
+		var cfg *storage.Config
+		if cfg, err = storage.LoadConfig(path); err == nil {
+			if stateFile != "" {
+				cfg.StateFile = stateFile
+			}
+			return cfg
+		}
+	}
+	fmt.Fprintf(os.Stderr, "failed to read config: %v\n", err)
+	os.Exit(1)
+	return nil
+}
+
+// runCommand dispatches args[0] to its subcommand and exits on failure.
+func runCommand(args []string) {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "cbq: unknown command %q\n\n", args[0])
+		printCommands()
+		os.Exit(2)
+	}
+	cfg := loadConfig()
+	if cfg.Ephemeral() && needsQueue(args) {
+		// A fresh in-memory queue would only mislead; the real one lives in
+		// the monitor's process.
+		fmt.Fprintf(os.Stderr, "cbq %s: the queue is kept in memory by the monitor, out of reach of commands (see cbq storage)\n", args[0])
+		os.Exit(1)
+	}
+	if err := cmd.run(newManager(cfg, reportRecovery), args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "usage: cbq %s\n", cmd.usage)
+			os.Exit(2)
+		}
+		fmt.Fprintf(os.Stderr, "cbq %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+// needsQueue reports whether the command in args reads or changes the
+// selected queue, rather than only the config, snippets or queue names.
+func needsQueue(args []string) bool {
+	switch args[0] {
+	case "storage", "queues":
+		return false
+	case "snippet":
+		return len(args) > 1 && args[1] == "use"
+	}
+	return true
+}
+
+func reportRecovery(r storage.Recovery) {
+	fmt.Fprintf(os.Stderr, "cbq: warning: %s\n", r)
+}
+
+func printCommands() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-28s %s\n", commands[name].usage, commands[name].help)
+	}
+}
+
+// newFlags returns a flag set for a subcommand's options. Parse errors are
+// reported by the flag package and surface as errUsage.
+func newFlags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {}
+	return fs
+}
+
+// parseIndices converts positional arguments to item indices.
+func parseIndices(args []string) ([]int, error) {
+	out := make([]int, len(args))
+	for i, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an index", a)
+		}
+		out[i] = n
+	}
+	return out, nil
+}
+
+func cmdList(mgr *queue.Manager, args []string) error {
+	fs := newFlags("list")
+	since := fs.String("since", "", "Only items copied since then: a time such as 2026-01-02 or 15:04, or a duration ago such as 2h")
+	until := fs.String("until", "", "Only items copied before then, in the same forms")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+	if *since != "" || *until != "" {
+		return listCreated(mgr, *since, *until)
+	}
+	state, err := mgr.GetStatus()
+	if err != nil {
+		return err
+	}
+	mode := state.Mode.Label()
+	status := "inactive"
+	if state.Active {
+		status = "active"
+	}
+	if state.Split != "" {
+		mode += ", split on " + state.Split
+	}
+	if state.Generator != "" {
+		mode += ", generating " + state.Generator
+	}
+	if state.Cycle {
+		mode += ", cycling"
+	}
+	fmt.Printf("%s, %s, %d items\n", status, mode, len(state.Items))
+	for i, item := range state.Items {
+		text := preview(item.Text)
+		if item.Pinned {
+			text = "[pinned] " + text
+		}
+		if item.Priority != 0 {
+			text = fmt.Sprintf("[%+d] %s", item.Priority, text)
+		}
+		if item.Repeat > 1 {
+			text = fmt.Sprintf("(×%d) %s", item.Repeat, text)
+		}
+		fmt.Printf("%3d  %s  %s\n", i, item.ID, text)
+	}
+	return nil
+}
+
+// listCreated prints the items copied between since and until, oldest
+// first, by ID since they are not listed with their neighbours.
+func listCreated(mgr *queue.Manager, since, until string) error {
+	now := time.Now()
+	from, to := time.Time{}, now.Add(time.Second)
+	var err error
+	if since != "" {
+		if from, err = parseTime(since, now); err != nil {
+			return err
+		}
+	}
+	if until != "" {
+		if to, err = parseTime(until, now); err != nil {
+			return err
+		}
+	}
+	items, err := mgr.CreatedBetween(from, to)
+	if err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		fmt.Println("No items copied then.")
+	}
+	for _, item := range items {
+		fmt.Printf("%s  %s  %s\n", item.Created.Local().Format("Jan 2 15:04:05"), item.ID, preview(item.Text))
+	}
+	return nil
+}
+
+// parseTime reads a time for list: a duration before now, a date, a time
+// of day today, or both, in local time.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.ParseInLocation("15:04", s, time.Local); err == nil {
+		y, m, d := now.Date()
+		return time.Date(y, m, d, t.Hour(), t.Minute(), 0, 0, time.Local), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a time such as 2026-01-02 15:04 nor a duration such as 2h", s)
+}
+
+func cmdQueues(_ *queue.Manager, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	cfg := loadConfig()
+	bolt := boltStorage(cfg)
+	if bolt == nil {
+		return errors.New("only the bolt backend keeps several queues (see cbq storage)")
+	}
+	names, err := bolt.Queues()
+	if err != nil {
+		return err
+	}
+	current := cmp.Or(cfg.Queue, storage.DefaultQueue)
+	if !slices.Contains(names, current) {
+		names = append(names, current)
+		slices.Sort(names)
+	}
+	for _, name := range names {
+		marker := "  "
+		if name == current {
+			marker = "* "
+		}
+		fmt.Println(marker + name)
+	}
+	return nil
+}
+
+// boltStorage returns the bolt database cfg selects, or nil if it selects
+// another backend or an ephemeral queue.
+func boltStorage(cfg *storage.Config) *storage.BoltStorage {
+	dir, err := storage.DefaultDir()
+	if err != nil {
+		return nil
+	}
+	bolt, _ := storage.Unwrap(cfg.Open(dir)).(*storage.BoltStorage)
+	return bolt
+}
+
+// preview shortens an item to a single line for listings.
+func preview(item string) string {
+	const max = 70
+	line := strings.ReplaceAll(item, "\n", "⏎")
+	if r := []rune(line); len(r) > max {
+		return string(r[:max-1]) + "…"
+	}
+	return line
+}
+
+func cmdInsert(mgr *queue.Manager, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	idx, err := parseIndices(args[:1])
+	if err != nil {
+		return err
+	}
+	return mgr.InsertAt(idx[0], args[1])
+}
+
+func cmdDelete(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	idx, err := parseIndices(args)
+	if err != nil {
+		return err
+	}
+	item, err := mgr.DeleteAt(idx[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Deleted: %s\n", preview(item))
+	return nil
+}
+
+func cmdReplace(mgr *queue.Manager, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	idx, err := parseIndices(args[:1])
+	if err != nil {
+		return err
+	}
+	return mgr.ReplaceAt(idx[0], args[1])
+}
+
+func cmdMove(mgr *queue.Manager, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	idx, err := parseIndices(args)
+	if err != nil {
+		return err
+	}
+	return mgr.Move(idx[0], idx[1])
+}
+
+func cmdSwap(mgr *queue.Manager, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	idx, err := parseIndices(args)
+	if err != nil {
+		return err
+	}
+	return mgr.Swap(idx[0], idx[1])
+}
+
+func cmdEdit(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	_, item, err := mgr.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+	edited, err := editor.Edit(item.Text)
+	if err != nil {
+		return err
+	}
+	if edited == item.Text {
+		fmt.Println("No changes.")
+		return nil
+	}
+	if err := mgr.EditItem(item.ID, item.Text, edited); err != nil {
+		if errors.Is(err, queue.ErrConflict) {
+			// Don't lose the user's work.
+			fmt.Fprintf(os.Stderr, "Your edit was not saved:\n%s\n", edited)
+		}
+		return err
+	}
+	return nil
+}
+
+func cmdTUI(mgr *queue.Manager, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	return tui.Run(mgr, tuiQueues(loadConfig()))
+}
+
+// tuiQueues lets the TUI switch between the queues of a bolt database, or
+// returns nil for the other backends, which hold a single queue.
+func tuiQueues(cfg *storage.Config) *tui.Queues {
+	bolt := boltStorage(cfg)
+	if bolt == nil {
+		return nil
+	}
+	return &tui.Queues{
+		Current: cmp.Or(cfg.Queue, storage.DefaultQueue),
+		List:    bolt.Queues,
+		Open: func(name string) (*queue.Manager, error) {
+			next := *cfg
+			next.Queue = name
+			if next.Ephemeral() {
+				return nil, fmt.Errorf("queue %s is kept in memory by the monitor", name)
+			}
+			return newManager(&next, reportRecovery), nil
+		},
+	}
+}
+
+func cmdSplit(mgr *queue.Manager, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errUsage
+	}
+	spec := "newline"
+	if len(args) == 2 {
+		spec = args[1]
+	}
+	splitter, err := queue.ParseSplitter(spec)
+	if err != nil {
+		return err
+	}
+	index, _, err := mgr.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+	n, err := mgr.SplitAt(index, splitter)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Split into %d items.\n", n)
+	return nil
+}
+
+func cmdMode(mgr *queue.Manager, args []string) error {
+	switch len(args) {
+	case 0:
+		state, err := mgr.GetStatus()
+		if err != nil {
+			return err
+		}
+		fmt.Println(state.Mode.Label())
+		return nil
+	case 1:
+		mode, err := storage.ParseMode(args[0])
+		if err != nil {
+			return err
+		}
+		return mgr.SetMode(mode)
+	}
+	return errUsage
+}
+
+func cmdModes(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	var modes []storage.Mode
+	for _, name := range strings.Split(args[0], ",") {
+		mode, err := storage.ParseMode(strings.TrimSpace(name))
+		if err != nil {
+			return err
+		}
+		modes = append(modes, mode)
+	}
+	return mgr.SetEnabledModes(modes)
+}
+
+func cmdPriority(mgr *queue.Manager, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	priority, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errUsage
+	}
+	_, item, err := mgr.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+	return mgr.SetPriority(item.ID, priority)
+}
+
+func cmdPin(mgr *queue.Manager, args []string) error {
+	switch len(args) {
+	case 0:
+		state, err := mgr.GetStatus()
+		if err != nil {
+			return err
+		}
+		for _, pin := range state.Pins {
+			fmt.Printf("%s  %s\n", pin.ID, preview(pin.Text))
+		}
+		return nil
+	case 1:
+		_, item, err := mgr.Lookup(args[0])
+		if err != nil {
+			return err
+		}
+		return mgr.Pin(item.ID)
+	}
+	return errUsage
+}
+
+func cmdUnpin(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	// A pinned item that was already pasted is only found among the pins.
+	ref := args[0]
+	if _, item, err := mgr.Lookup(ref); err == nil && item.Pinned {
+		ref = item.ID
+	}
+	_, err := mgr.Unpin(ref)
+	return err
+}
+
+func cmdPinPosition(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return mgr.SetPinPosition(storage.PinPosition(args[0]))
+}
+
+func cmdCycle(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return errUsage
+	}
+	return mgr.SetCycleMode(args[0] == "on")
+}
+
+func cmdRepeat(mgr *queue.Manager, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	times, err := strconv.Atoi(args[1])
+	if err != nil || times < 1 {
+		return errUsage
+	}
+	_, item, err := mgr.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+	return mgr.SetRepeat(item.ID, times)
+}
+
+func cmdSplitMode(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	spec := args[0]
+	if spec == "off" {
+		spec = ""
+	}
+	return mgr.SetSplitMode(spec)
+}
+
+func cmdJoin(mgr *queue.Manager, args []string) error {
+	fs := newFlags("join")
+	with := fs.String("with", "", "Separator or template for this join (default: the queue's join-with setting)")
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	var indices []int
+	for _, ref := range fs.Args() {
+		index, _, err := mgr.Lookup(ref)
+		if err != nil {
+			return err
+		}
+		indices = append(indices, index)
+	}
+	joined, err := mgr.Join(indices, *with)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Joined: %s\n", preview(joined))
+	return nil
+}
+
+func cmdJoinWith(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	return mgr.SetJoinSeparator(args[0])
+}
+
+func cmdTransform(mgr *queue.Manager, args []string) error {
+	if len(args) == 0 {
+		state, err := mgr.GetStatus()
+		if err != nil {
+			return err
+		}
+		for _, stage := range []struct {
+			name  string
+			chain transform.Chain
+		}{{"capture", state.CaptureTransforms}, {"paste", state.PasteTransforms}} {
+			chain := stage.chain.String()
+			if chain == "" {
+				chain = "off"
+			}
+			fmt.Printf("%-8s %s\n", stage.name+":", chain)
+		}
+		fmt.Printf("available: %s\n", strings.Join(transform.Names(), ", "))
+		return nil
+	}
+	if len(args) != 2 || (args[0] != "capture" && args[0] != "paste") {
+		return errUsage
+	}
+	var chain transform.Chain
+	if args[1] != "off" {
+		var err error
+		if chain, err = transform.Parse(args[1]); err != nil {
+			return err
+		}
+	}
+	return mgr.SetTransforms(args[0] == "paste", chain)
+}
+
+func cmdTemplate(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	spec := args[0]
+	if spec == "off" {
+		spec = ""
+	}
+	return mgr.SetTemplate(spec)
+}
+
+func cmdMeta(mgr *queue.Manager, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	_, item, err := mgr.Lookup(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		keys := make([]string, 0, len(item.Meta))
+		for key := range item.Meta {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s=%s\n", key, item.Meta[key])
+		}
+		return nil
+	}
+	for _, pair := range args[1:] {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return errUsage
+		}
+		if err := mgr.SetMeta(item.ID, key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func cmdGenerate(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	pattern := args[0]
+	if pattern == "off" {
+		pattern = ""
+	}
+	return mgr.SetGenerator(pattern)
+}
+
+func cmdPush(mgr *queue.Manager, args []string) error {
+	fs := newFlags("push")
+	split := fs.String("split", "newline", "Delimiter for stdin: newline, nul, comma, tab or re:<pattern>")
+	if err := fs.Parse(args); err != nil || fs.NArg() == 0 {
+		return errUsage
+	}
+
+	items := fs.Args()
+	if fs.NArg() == 1 && fs.Arg(0) == "-" {
+		splitter, err := queue.ParseSplitter(*split)
+		if err != nil {
+			return err
+		}
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		items = splitter.Split(string(data))
+	}
+	if len(items) == 0 {
+		return errors.New("nothing to push")
+	}
+	started, err := mgr.AddAll(items, true)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Pushed %d items.\n", len(items))
+	reportStarted(started)
+	return nil
+}
+
+// reportStarted tells the user if queueing items started the queue, which
+// starting it with Cmd+I would have emptied.
+func reportStarted(started bool) {
+	if started {
+		fmt.Println("The queue was inactive and has been started so the items can be pasted.")
+	}
+}
+
+func cmdSnippet(mgr *queue.Manager, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	path, err := snippet.DefaultPath()
+	if err != nil {
+		return err
+	}
+	store := snippet.NewStore(path)
+	lib, err := store.Load()
+	if err != nil {
+		return err
+	}
+
+	verb, args := args[0], args[1:]
+	switch {
+	case verb == "list" && len(args) == 0:
+		for _, name := range lib.Names() {
+			fmt.Printf("%-16s %s\n", name, preview(lib.Snippets[name]))
+		}
+		for _, name := range lib.GroupNames() {
+			fmt.Printf("%-16s group: %s\n", name, strings.Join(lib.Groups[name], ", "))
+		}
+		return nil
+	case verb == "use" && len(args) > 0:
+		texts, err := lib.Resolve(args...)
+		if err != nil {
+			return err
+		}
+		started, err := mgr.AddAll(texts, true)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Queued %d snippets.\n", len(texts))
+		reportStarted(started)
+		return nil
+	case verb == "add" && len(args) == 2:
+		text := args[1]
+		if text == "-" {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				return err
+			}
+			text = strings.TrimSuffix(string(data), "\n")
+		}
+		err = lib.Set(args[0], text)
+	case verb == "rm" && len(args) == 1:
+		err = lib.Remove(args[0])
+	case verb == "group" && len(args) >= 2:
+		err = lib.SetGroup(args[0], args[1:])
+	default:
+		return errUsage
+	}
+	if err != nil {
+		return err
+	}
+	return store.Save(lib)
+}
+
+func cmdImport(mgr *queue.Manager, args []string) error {
+	fs := newFlags("import")
+	format := fs.String("format", "", "lines, nul, json, ndjson or csv (default: from the file extension)")
+	column := fs.Int("column", 0, "CSV column holding the items, zero-based")
+	header := fs.Bool("header", false, "CSV: skip the first row")
+	replace := fs.Bool("replace", false, "Replace the queue instead of appending")
+	history := fs.Bool("history", false, "Add the items to the history instead of the queue")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 1 {
+		return errUsage
+	}
+	path := fs.Arg(0)
+
+	f := transfer.FormatForPath(path)
+	if *format != "" {
+		var err error
+		if f, err = transfer.ParseFormat(*format); err != nil {
+			return err
+		}
+	}
+
+	var r io.Reader = os.Stdin
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	items, err := transfer.Read(r, f, transfer.Options{Column: *column, SkipHeader: *header})
+	if err != nil {
+		return err
+	}
+
+	var started bool
+	switch {
+	case *history && *replace:
+		err = mgr.ReplaceHistory(items)
+	case *history:
+		err = mgr.AddHistory(items)
+	case *replace:
+		started, err = mgr.ReplaceAll(items, true)
+	default:
+		started, err = mgr.AddAll(items, true)
+	}
+	if err != nil {
+		return err
+	}
+	if *history && len(items) > queue.HistoryLimit {
+		fmt.Printf("Imported %d items; the history keeps the last %d.\n", len(items), queue.HistoryLimit)
+		return nil
+	}
+	fmt.Printf("Imported %d items.\n", len(items))
+	reportStarted(started)
+	return nil
+}
+
+func cmdExport(mgr *queue.Manager, args []string) error {
+	fs := newFlags("export")
+	format := fs.String("format", "", "lines, nul, json, ndjson or csv (default: from -o, else lines)")
+	out := fs.String("o", "", "Write to this file instead of stdout")
+	history := fs.Bool("history", false, "Write the history instead of the queue")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 0 {
+		return errUsage
+	}
+
+	f := transfer.FormatForPath(*out)
+	if *format != "" {
+		var err error
+		if f, err = transfer.ParseFormat(*format); err != nil {
+			return err
+		}
+	}
+
+	state, err := mgr.GetStatus()
+	if err != nil {
+		return err
+	}
+	export := transfer.Export
+	if *history {
+		export = transfer.ExportHistory
+	}
+	if *out == "" {
+		return export(os.Stdout, state, f)
+	}
+	file, err := os.OpenFile(*out, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if err := export(file, state, f); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func cmdHistory(mgr *queue.Manager, args []string) error {
+	switch {
+	case len(args) == 1 && args[0] == "clear":
+		if err := mgr.ReplaceHistory(nil); err != nil {
+			return err
+		}
+		fmt.Println("History cleared.")
+		return nil
+	case len(args) != 0:
+		return errUsage
+	}
+	state, err := mgr.GetStatus()
+	if err != nil {
+		return err
+	}
+	if len(state.History) == 0 {
+		fmt.Println("Nothing pasted yet.")
+	}
+	for i, item := range slices.Backward(state.History) {
+		fmt.Printf("%3d  %s\n", len(state.History)-i, preview(item.Text)) // 1 is the newest
+	}
+	return nil
+}
+
+func cmdStorage(_ *queue.Manager, args []string) error {
+	if len(args) > 1 {
+		return errUsage
+	}
+	dir, err := storage.DefaultDir()
+	if err != nil {
+		return err
+	}
+	path, err := storage.DefaultConfigPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := storage.LoadConfig(path)
+	if err != nil {
+		return err
+	}
+	current := cmp.Or(cfg.Storage, storage.BackendJSON)
+	if len(args) == 0 {
+		fmt.Println(current)
+		return nil
+	}
+	if cfg.StateFile != "" || stateFile != "" {
+		// Both backends would use the same file.
+		return fmt.Errorf("cannot move the queue while a state file is set; remove state_file from %s and --state-file first", path)
+	}
+
+	backend, err := storage.ParseBackend(args[0])
+	if err != nil {
+		return err
+	}
+	if backend == current {
+		return fmt.Errorf("already using %s storage", backend)
+	}
+	next := *cfg
+	next.Storage = backend
+	if cfg.Ephemeral() || next.Ephemeral() {
+		// The items in the monitor's memory cannot be reached from here, and
+		// must not be written to disk.
+		if err := storage.SaveConfig(path, &next); err != nil {
+			return err
+		}
+		fmt.Printf("Switched to %s storage without moving any items. Restart the monitor to pick up the change.\n", backend)
+		if backend == storage.BackendMemory {
+			fmt.Printf("The %s files in %s were left in place; delete them to remove the items stored so far.\n", current, dir)
+		}
+		return nil
+	}
+	state, err := storage.Migrate(cfg.Open(dir), next.Open(dir))
+	if err != nil {
+		return err
+	}
+	if err := storage.SaveConfig(path, &next); err != nil {
+		return err
+	}
+	fmt.Printf("Moved %d items to %s storage; the %s files were left in place. Restart the monitor to pick up the change.\n",
+		len(state.Items), backend, current)
+	return nil
+}
+
+func cmdBackup(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	switch args[0] {
+	case "list":
+		snapshots, err := mgr.Snapshots()
+		if err != nil {
+			return err
+		}
+		if len(snapshots) == 0 {
+			fmt.Println("No snapshots yet.")
+		}
+		for _, snap := range snapshots {
+			fmt.Printf("%s  %s  %-10s %d items\n", snap.Name, snap.Time.Local().Format("Jan 2 15:04"), snap.Reason, snap.Items)
+		}
+		return nil
+	case "now":
+		snap, err := mgr.TakeSnapshot("manual")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Snapshot %s holds %d items.\n", snap.Name, snap.Items)
+		return nil
+	}
+	return errUsage
+}
+
+func cmdRestore(mgr *queue.Manager, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	snap, err := mgr.Restore(args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %d items from %s.\n", snap.Items, snap.Name)
+	return nil
+}
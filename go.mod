@@ -4,7 +4,13 @@ go 1.25.0
 
 require (
 	github.com/atotto/clipboard v0.1.4
+	github.com/klauspost/compress v1.18.0
 	github.com/robotn/gohook v0.42.3
+	go.etcd.io/bbolt v1.5.0
+	golang.org/x/term v0.45.0
 )
 
-require github.com/vcaesar/keycode v0.10.1 // indirect
+require (
+	github.com/vcaesar/keycode v0.10.1 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+)
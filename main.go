@@ -1,16 +1,19 @@
 package main
 
 import (
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"text/template"
 	"bytes"
 
 	"github.com/matouschdavid/Clipboard-queue/pkg/monitor"
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
 )
 
 var version = "v0.1.0" // overridden by -ldflags "-X main.version=..."
@@ -22,23 +25,59 @@ const plistTemplate = `<?xml version="1.0" encoding="UTF-8"?>
 <plist version="1.0">
 <dict>
     <key>Label</key>
-    <string>{{.Label}}</string>
+    <string>{{xml .Label}}</string>
     <key>ProgramArguments</key>
     <array>
-        <string>{{.BinaryPath}}</string>
+        <string>{{xml .BinaryPath}}</string>
+{{- range .Args}}
+        <string>{{xml .}}</string>
+{{- end}}
     </array>
     <key>RunAtLoad</key>
     <true/>
     <key>KeepAlive</key>
     <true/>
+{{- if .Home}}
+    <key>EnvironmentVariables</key>
+    <dict>
+        <key>CBQ_HOME</key>
+        <string>{{xml .Home}}</string>
+    </dict>
+{{- end}}
     <key>StandardOutPath</key>
-    <string>{{.LogPath}}</string>
+    <string>{{xml .LogPath}}</string>
     <key>StandardErrorPath</key>
-    <string>{{.LogPath}}</string>
+    <string>{{xml .LogPath}}</string>
 </dict>
 </plist>
 `
 
+// plistData fills in plistTemplate.
+type plistData struct {
+	Label, BinaryPath, LogPath, Home string
+	Args                             []string
+}
+
+// renderPlist returns the launch agent for data. Every value is escaped,
+// since paths and arguments may contain & or <.
+func renderPlist(data plistData) ([]byte, error) {
+	tmpl, err := template.New("plist").Funcs(template.FuncMap{
+		"xml": func(s string) (string, error) {
+			var b strings.Builder
+			err := xml.EscapeText(&b, []byte(s))
+			return b.String(), err
+		},
+	}).Parse(plistTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
 func plistPath() (string, error) {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -47,7 +86,9 @@ func plistPath() (string, error) {
 	return filepath.Join(home, "Library", "LaunchAgents", plistLabel+".plist"), nil
 }
 
-func installAgent() error {
+// installAgent writes and loads the launch agent. args are passed to the
+// monitor on every start, so options given alongside --install persist.
+func installAgent(args []string) error {
 	exePath, err := os.Executable()
 	if err != nil {
 		return fmt.Errorf("could not find binary path: %w", err)
@@ -58,32 +99,36 @@ func installAgent() error {
 		return fmt.Errorf("could not resolve binary path: %w", err)
 	}
 
-	home, err := os.UserHomeDir()
+	logPath, err := storage.DefaultLogPath()
 	if err != nil {
 		return err
 	}
-	logPath := filepath.Join(home, ".cbq", "cbq.log")
+	// launchd does not create the directory of the log.
+	if err := os.MkdirAll(filepath.Dir(logPath), 0755); err != nil {
+		return err
+	}
+	// The login item does not inherit the environment, so pass CBQ_HOME on.
+	cbqHome := os.Getenv(storage.HomeEnv)
+	if cbqHome != "" {
+		if cbqHome, err = filepath.Abs(cbqHome); err != nil {
+			return err
+		}
+	}
 
 	dest, err := plistPath()
 	if err != nil {
 		return err
 	}
 
-	tmpl, err := template.New("plist").Parse(plistTemplate)
+	plist, err := renderPlist(plistData{plistLabel, exePath, logPath, cbqHome, args})
 	if err != nil {
 		return err
 	}
-	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, struct {
-		Label, BinaryPath, LogPath string
-	}{plistLabel, exePath, logPath}); err != nil {
-		return err
-	}
 
 	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
 		return err
 	}
-	if err := os.WriteFile(dest, buf.Bytes(), 0644); err != nil {
+	if err := os.WriteFile(dest, plist, 0644); err != nil {
 		return err
 	}
 
@@ -120,13 +165,46 @@ func main() {
 	showVersion := flag.Bool("version", false, "Print version and exit")
 	install     := flag.Bool("install", false, "Install CBQ as a login item (autostart on login)")
 	uninstall   := flag.Bool("uninstall", false, "Remove CBQ login item")
+	pasteMode   := flag.String("paste-mode", string(monitor.PasteOnKeyUp), "When to advance after a paste: keyup, delay or synthesize (Ctrl+Cmd+V)")
+	pasteDelay  := flag.Duration("paste-delay", monitor.DefaultPasteDelay, "How long to let the OS paste before the next item replaces it")
+	ephemeral   := flag.Bool("ephemeral", false, "Keep the queue in memory only, so nothing copied reaches the disk or the log")
+	flag.StringVar(&stateFile, "state-file", "", "Keep the queue in this file instead of the data directory")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: cbq [flags] [command]\n\nWithout a command, cbq runs the hotkey monitor.\n\nFlags:\n")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr)
+		printCommands()
+	}
 	flag.Parse()
+	if stateFile != "" {
+		// Absolute, so that the login item, which --install passes it on
+		// to, finds it from any directory.
+		abs, err := filepath.Abs(stateFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		stateFile = abs
+	}
+	// Checked before --install too, so that it never sets up a login item
+	// that exits right away and is restarted over and over.
+	mode, err := monitor.ParsePasteMode(*pasteMode)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	switch {
+	case flag.NArg() > 0:
+		runCommand(flag.Args())
 	case *showVersion:
 		fmt.Println(version)
 	case *install:
-		if err := installAgent(); err != nil {
+		var args []string
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name != "install" {
+				args = append(args, "--"+f.Name+"="+f.Value.String())
+			}
+		})
+		if err := installAgent(args); err != nil {
 			log.Fatalf("Install failed: %v", err)
 		}
 	case *uninstall:
@@ -135,6 +213,14 @@ func main() {
 		}
 	default:
 		log.Printf("CBQ %s", version)
-		monitor.Start()
+		cfg := loadConfig()
+		if *ephemeral {
+			cfg.Storage = storage.BackendMemory
+		}
+		monitor.Start(newManager(cfg, monitor.ReportRecovery), monitor.Options{
+			PasteMode:  mode,
+			PasteDelay: *pasteDelay,
+			Ephemeral:  cfg.Ephemeral(),
+		})
 	}
 }
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/queue"
+	"github.com/matouschdavid/Clipboard-queue/pkg/snippet"
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+type fakeClipboard struct{ content string }
+
+func (f *fakeClipboard) Read() (string, error)   { return f.content, nil }
+func (f *fakeClipboard) Write(text string) error { f.content = text; return nil }
+
+func TestSnippetGroup_Stack(t *testing.T) {
+	t.Setenv(storage.HomeEnv, t.TempDir())
+	s := storage.NewMemoryStorage()
+	if err := s.Save(&storage.State{Mode: storage.ModeStack, Items: []storage.Item{}}); err != nil {
+		t.Fatal(err)
+	}
+	mgr := queue.NewManager(s, &fakeClipboard{})
+
+	for _, args := range [][]string{
+		{"add", "greeting", "Hi"},
+		{"add", "body", "Text"},
+		{"add", "sig", "Bye"},
+		{"group", "reply", "greeting", "body", "sig"},
+		{"use", "reply"},
+	} {
+		if err := cmdSnippet(mgr, args); err != nil {
+			t.Fatalf("snippet %v: %v", args, err)
+		}
+	}
+	state, _ := mgr.GetStatus()
+	if !state.Active {
+		t.Error("expected the queue started")
+	}
+	var pasted []string
+	for range 3 {
+		item, err := mgr.Pop()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pasted = append(pasted, item)
+	}
+	if !slices.Equal(pasted, []string{"Hi", "Text", "Bye"}) {
+		t.Errorf("expected the group in its order, got %q", pasted)
+	}
+}
+
+// Snippets can be managed while the queue is kept in memory.
+func TestSnippet_Ephemeral(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv(storage.HomeEnv, home)
+	cfg := &storage.Config{Storage: storage.BackendMemory}
+	if !cfg.Ephemeral() {
+		t.Fatal("expected an ephemeral config")
+	}
+
+	mgr := newManager(cfg, nil)
+	for _, args := range [][]string{
+		{"snippet", "add", "greeting", "Hi"},
+		{"snippet", "add", "sig", "Bye"},
+		{"snippet", "group", "reply", "greeting", "sig"},
+		{"snippet", "list"},
+		{"snippet", "rm", "sig"},
+	} {
+		if needsQueue(args) {
+			t.Errorf("%v is refused for an ephemeral queue", args)
+		}
+		if err := cmdSnippet(mgr, args[1:]); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+	lib, err := snippet.NewStore(filepath.Join(home, "snippets.json")).Load()
+	if err != nil || lib.Snippets["greeting"] != "Hi" || lib.Snippets["sig"] != "" {
+		t.Errorf("unexpected snippets %+v: %v", lib, err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "state.json")); !os.IsNotExist(err) {
+		t.Errorf("expected no state file, got %v", err)
+	}
+	for _, args := range [][]string{{"snippet", "use", "greeting"}, {"list"}, {"push", "a"}} {
+		if !needsQueue(args) {
+			t.Errorf("%v is allowed for an ephemeral queue", args)
+		}
+	}
+}
+
+func TestRenderPlist_Escapes(t *testing.T) {
+	data := plistData{
+		Label:      plistLabel,
+		BinaryPath: "/Applications/R&D/cbq",
+		LogPath:    "/Users/me/<logs>/cbq.log",
+		Home:       "/Users/me/Q&A",
+		Args:       []string{"--state-file=/tmp/a&b.json"},
+	}
+	plist, err := renderPlist(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strs []string
+	d := xml.NewDecoder(bytes.NewReader(plist))
+	d.Strict = true
+	inString := false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("invalid plist: %v\n%s", err, plist)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			inString = tok.Name.Local == "string"
+		case xml.EndElement:
+			inString = false
+		case xml.CharData:
+			if inString {
+				strs = append(strs, string(tok))
+			}
+		}
+	}
+	for _, want := range []string{data.BinaryPath, data.LogPath, data.Home, data.Args[0]} {
+		found := false
+		for _, s := range strs {
+			found = found || s == want
+		}
+		if !found {
+			t.Errorf("expected %q in the plist, got %q", want, strs)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	now := time.Date(2026, 3, 4, 12, 30, 0, 0, time.Local)
+	for in, want := range map[string]time.Time{
+		"2h":               now.Add(-2 * time.Hour),
+		"2026-01-02":       time.Date(2026, 1, 2, 0, 0, 0, 0, time.Local),
+		"2026-01-02 15:04": time.Date(2026, 1, 2, 15, 4, 0, 0, time.Local),
+		"09:15":            time.Date(2026, 3, 4, 9, 15, 0, 0, time.Local),
+	} {
+		if got, err := parseTime(in, now); err != nil || !got.Equal(want) {
+			t.Errorf("parseTime(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := parseTime("yesterday", now); err == nil {
+		t.Error("expected an error for an unknown form")
+	}
+}
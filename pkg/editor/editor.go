@@ -0,0 +1,63 @@
+// Package editor lets the user change a piece of text in their own editor.
+package editor
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Command returns the user's editor command line: $VISUAL, then $EDITOR,
+// falling back to vi. Values such as "code --wait" are split into fields.
+func Command() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	return []string{"vi"}
+}
+
+// Edit writes text to a private temp file, opens it in the user's editor
+// attached to the current terminal and returns the saved contents.
+//
+// Most editors end files with a newline; if text had no trailing newline,
+// one added by the editor is dropped again.
+func Edit(text string) (string, error) {
+	f, err := os.CreateTemp("", "cbq-edit-*.txt")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	defer os.Remove(name)
+
+	if _, err := f.WriteString(text); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+
+	argv := append(Command(), name)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exit *exec.ExitError
+		if errors.As(err, &exit) {
+			return "", errors.New("editor exited with an error, item left unchanged")
+		}
+		return "", err
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	edited := string(data)
+	if !strings.HasSuffix(text, "\n") {
+		edited = strings.TrimSuffix(edited, "\n")
+	}
+	return edited, nil
+}
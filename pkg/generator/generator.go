@@ -0,0 +1,191 @@
+// Package generator produces clipboard values from a pattern, such as
+// user-{n:001..100}, so a queue can hand out test data without the values
+// being copied first.
+//
+// A pattern is literal text with placeholders in braces:
+//
+//	{n}            a counter starting at 1
+//	{n:5}          a counter starting at 5
+//	{n:001}        a counter starting at 1, zero-padded to three digits
+//	{n:1..10}      1 to 10, after which the generator is exhausted
+//	{n:0..100..5}  0 to 100 in steps of 5
+//	{date}         the current date as 2006-01-02
+//	{date:layout}  the current time in a Go time layout, e.g. {date:15:04}
+//	{uuid}         a random version 4 UUID
+//
+// {{ and }} stand for literal braces. Every {n} in a pattern shares the
+// same position, so user-{n}@example.com/{n} repeats the number.
+package generator
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Generator produces the values of a pattern.
+type Generator struct {
+	pattern string
+	parts   []part
+}
+
+// part is a literal (kind "") or a placeholder.
+type part struct {
+	kind    string // "", "n", "date" or "uuid"
+	text    string // literal text or date layout
+	start   int
+	end     int // inclusive; only used if bounded
+	step    int
+	width   int // zero-pad width, 0 for none
+	bounded bool
+}
+
+// Parse compiles a pattern.
+func Parse(pattern string) (*Generator, error) {
+	g := &Generator{pattern: pattern}
+	var lit strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '{' && strings.HasPrefix(pattern[i:], "{{"):
+			lit.WriteByte('{')
+			i++
+		case c == '}' && strings.HasPrefix(pattern[i:], "}}"):
+			lit.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(pattern[i:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("unclosed { at offset %d", i)
+			}
+			p, err := parsePlaceholder(pattern[i+1 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			if lit.Len() > 0 {
+				g.parts = append(g.parts, part{text: lit.String()})
+				lit.Reset()
+			}
+			g.parts = append(g.parts, p)
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unexpected } at offset %d (use }} for a literal brace)", i)
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	if lit.Len() > 0 {
+		g.parts = append(g.parts, part{text: lit.String()})
+	}
+	return g, nil
+}
+
+func parsePlaceholder(s string) (part, error) {
+	name, arg, hasArg := strings.Cut(s, ":")
+	switch name {
+	case "uuid":
+		if hasArg {
+			return part{}, fmt.Errorf("{uuid} takes no argument")
+		}
+		return part{kind: "uuid"}, nil
+	case "date":
+		layout := time.DateOnly
+		if hasArg {
+			if arg == "" {
+				return part{}, fmt.Errorf("empty date layout")
+			}
+			layout = arg
+		}
+		return part{kind: "date", text: layout}, nil
+	case "n":
+		if !hasArg {
+			return part{kind: "n", start: 1, step: 1}, nil
+		}
+		return parseCounter(arg)
+	}
+	return part{}, fmt.Errorf("unknown placeholder {%s} (want n, date or uuid)", s)
+}
+
+// parseCounter reads start[..end[..step]]. A leading zero on start or end
+// sets the zero-pad width, as with shell brace expansion.
+func parseCounter(arg string) (part, error) {
+	fields := strings.Split(arg, "..")
+	if len(fields) > 3 {
+		return part{}, fmt.Errorf("invalid counter %q (want start, start..end or start..end..step)", arg)
+	}
+	nums := make([]int, len(fields))
+	p := part{kind: "n", step: 1}
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil {
+			return part{}, fmt.Errorf("invalid counter %q: %q is not a number", arg, f)
+		}
+		nums[i] = n
+		if i < 2 && len(f) > 1 && strings.TrimPrefix(f, "-")[0] == '0' {
+			p.width = max(p.width, len(f))
+		}
+	}
+	p.start = nums[0]
+	if len(nums) >= 2 {
+		p.end, p.bounded = nums[1], true
+		if p.end < p.start {
+			p.step = -1
+		}
+	}
+	if len(nums) == 3 {
+		if nums[2] == 0 {
+			return part{}, fmt.Errorf("invalid counter %q: step must not be zero", arg)
+		}
+		p.step = nums[2]
+		if nums[2] < 0 {
+			p.step = -nums[2]
+		}
+		if p.end < p.start {
+			p.step = -p.step
+		}
+	}
+	return p, nil
+}
+
+// String returns the pattern the generator was parsed from.
+func (g *Generator) String() string {
+	return g.pattern
+}
+
+// Generate returns the value at position i (counting from zero), with dates
+// taken from now. It returns false once a bounded counter is exhausted.
+func (g *Generator) Generate(i int, now time.Time) (string, bool) {
+	var b strings.Builder
+	for _, p := range g.parts {
+		switch p.kind {
+		case "":
+			b.WriteString(p.text)
+		case "n":
+			n := p.start + i*p.step
+			if p.bounded && (p.step > 0 && n > p.end || p.step < 0 && n < p.end) {
+				return "", false
+			}
+			if n < 0 {
+				fmt.Fprintf(&b, "-%0*d", max(p.width-1, 0), -n)
+			} else {
+				fmt.Fprintf(&b, "%0*d", p.width, n)
+			}
+		case "date":
+			b.WriteString(now.Format(p.text))
+		case "uuid":
+			b.WriteString(newUUID())
+		}
+	}
+	return b.String(), true
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
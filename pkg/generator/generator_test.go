@@ -0,0 +1,66 @@
+package generator
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestGenerate(t *testing.T) {
+	now := time.Date(2024, 3, 1, 14, 5, 0, 0, time.UTC)
+	cases := []struct {
+		pattern string
+		want    []string // values from position 0; "" marks exhaustion
+	}{
+		{"user-{n}", []string{"user-1", "user-2", "user-3"}},
+		{"user-{n:001}", []string{"user-001", "user-002"}},
+		{"{n:8..10}", []string{"8", "9", "10", ""}},
+		{"{n:08..10}", []string{"08", "09", "10", ""}},
+		{"{n:0..10..5}", []string{"0", "5", "10", ""}},
+		{"{n:3..1}", []string{"3", "2", "1", ""}},
+		{"row {n:5}/{n:5}", []string{"row 5/5", "row 6/6"}},
+		{"{date}", []string{"2024-03-01"}},
+		{"{date:15:04} #{n}", []string{"14:05 #1"}},
+		{"{{n}} {n}", []string{"{n} 1"}},
+		{"fixed", []string{"fixed", "fixed"}},
+	}
+	for _, tc := range cases {
+		g, err := Parse(tc.pattern)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.pattern, err)
+		}
+		for i, want := range tc.want {
+			got, ok := g.Generate(i, now)
+			if want == "" {
+				if ok {
+					t.Errorf("%s[%d]: expected exhaustion, got %q", tc.pattern, i, got)
+				}
+				continue
+			}
+			if !ok || got != want {
+				t.Errorf("%s[%d]: got %q (%v), want %q", tc.pattern, i, got, ok, want)
+			}
+		}
+	}
+}
+
+func TestUUID(t *testing.T) {
+	g, err := Parse("{uuid}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, _ := g.Generate(0, time.Now())
+	b, _ := g.Generate(0, time.Now())
+	re := regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !re.MatchString(a) || a == b {
+		t.Errorf("expected two distinct v4 UUIDs, got %q and %q", a, b)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{"{n", "x}", "{nope}", "{n:a..b}", "{n:1..5..0}", "{n:1..2..3..4}", "{uuid:x}", "{date:}"} {
+		if _, err := Parse(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
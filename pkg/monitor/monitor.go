@@ -1,14 +1,16 @@
 package monitor
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
-	"sync"
+	"slices"
 	"syscall"
 	"time"
+	"unicode/utf8"
 
 	hook "github.com/robotn/gohook"
 
@@ -19,6 +21,7 @@ import (
 // Modifier masks for gohook.
 const (
 	maskMeta = 0x0004 | 0x0040 // Cmd on macOS
+	maskCtrl = 0x0002 | 0x0020
 )
 
 // macOS virtual keycodes.
@@ -27,24 +30,41 @@ const (
 	keyI = 34
 	keyM = 46
 	keyR = 15
+	keyJ = 38
 )
 
 // pollInterval is how often the clipboard is checked for new content.
 // This catches both Cmd+C copies and browser "copy to clipboard" buttons.
 const pollInterval = 250 * time.Millisecond
 
+// snapshotInterval is how often the state is backed up while the monitor
+// runs, besides whenever the queue is started or stopped.
+const snapshotInterval = 15 * time.Minute
+
 // notify sends a macOS system notification via osascript.
 func notify(title, message string) {
 	script := fmt.Sprintf(`display notification %q with title %q`, message, title)
 	_ = exec.Command("osascript", "-e", script).Run()
 }
 
-func newManager() *queue.Manager {
-	path, err := storage.GetDefaultPath()
-	if err != nil {
-		log.Fatalf("failed to get storage path: %v", err)
+// ReportRecovery logs how a damaged state file was recovered and tells the
+// user with a notification.
+func ReportRecovery(r storage.Recovery) {
+	log.Printf("Warning: %s", r)
+	message := fmt.Sprintf("The queue file was damaged; %d items were recovered. See the log for details.", r.Items)
+	if r.FromBackup {
+		message = fmt.Sprintf("The queue file was damaged and restored from its backup (%d items).", r.Items)
+	}
+	notify("CBQ", message)
+}
+
+// sendPaste injects a Cmd+V keystroke into the frontmost app via osascript.
+func sendPaste() error {
+	script := `tell application "System Events" to keystroke "v" using command down`
+	if out, err := exec.Command("osascript", "-e", script).CombinedOutput(); err != nil {
+		return fmt.Errorf("%w: %s", err, out)
 	}
-	return queue.NewManager(storage.NewJSONStorage(path), &queue.SystemClipboard{})
+	return nil
 }
 
 // clipboardPoller captures every clipboard change while the queue is active.
@@ -53,13 +73,15 @@ func newManager() *queue.Manager {
 // hook mistakes it for a new user copy.
 //
 // To prevent re-adding items that cbq itself wrote via sync(), the poller
-// skips any clipboard value that is already present in the queue.
+// skips any clipboard value that is already present in the queue or that
+// sync() last wrote, which differs from the item with paste transformations.
 type clipboardPoller struct {
-	stop chan struct{}
+	stop      chan struct{}
+	ephemeral bool
 }
 
-func startPoller(mgr *queue.Manager) *clipboardPoller {
-	p := &clipboardPoller{stop: make(chan struct{})}
+func startPoller(mgr *queue.Manager, ephemeral bool) *clipboardPoller {
+	p := &clipboardPoller{stop: make(chan struct{}), ephemeral: ephemeral}
 	go p.run(mgr)
 	return p
 }
@@ -86,6 +108,15 @@ func (p *clipboardPoller) run(mgr *queue.Manager) {
 		case <-p.stop:
 			return
 		case <-ticker.C:
+			// Pick up queue changes made by cbq commands or by hand first,
+			// so their clipboard writes are known to be cbq's own. The
+			// state it returns serves the whole tick, so the storage is
+			// locked once per tick unless there is something to capture.
+			state, _, err := mgr.Refresh()
+			if err != nil {
+				log.Printf("Error refreshing state: %v", err)
+				continue
+			}
 			text, err := cb.Read()
 			if err != nil || text == "" || text == lastSeen {
 				continue
@@ -94,27 +125,49 @@ func (p *clipboardPoller) run(mgr *queue.Manager) {
 
 			// Skip values that cbq already has in the queue (written back
 			// by sync() after an add or pop — not a new user copy).
-			state, err := mgr.GetStatus()
-			if err != nil {
+			if mgr.Wrote(text) || slices.ContainsFunc(state.Items, func(it storage.Item) bool { return it.Text == text }) {
 				continue
 			}
-			for _, item := range state.Items {
-				if item == text {
-					goto nextTick
-				}
-			}
 
-			if err := mgr.AddAndSync(text); err != nil {
+			if err := mgr.Capture(text); err != nil {
 				log.Printf("Poller: error adding to queue: %v", err)
 			} else {
-				log.Printf("Captured: %q", text)
+				log.Printf("Captured: %s", describe(text, p.ephemeral))
 			}
-		nextTick:
 		}
 	}
 }
 
-func Start() {
+// describe returns how text appears in the log: quoted, or only its
+// length if it must not be written anywhere.
+func describe(text string, ephemeral bool) string {
+	if ephemeral {
+		return fmt.Sprintf("%d characters", utf8.RuneCountInString(text))
+	}
+	return fmt.Sprintf("%q", text)
+}
+
+// takeSnapshots backs up the state every snapshotInterval until stop is
+// closed. Unchanged states are not stored again.
+func takeSnapshots(mgr *queue.Manager, stop <-chan struct{}) {
+	ticker := time.NewTicker(snapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := mgr.TakeSnapshot("periodic"); errors.Is(err, queue.ErrNoSnapshots) {
+				return
+			} else if err != nil {
+				log.Printf("Error backing up state: %v", err)
+			}
+		}
+	}
+}
+
+// Start runs the hotkey loop against mgr until the process is signalled.
+func Start(mgr *queue.Manager, opts Options) {
 	// Graceful shutdown on SIGINT / SIGTERM.
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
@@ -127,7 +180,9 @@ func Start() {
 	evChan := hook.Start()
 	defer hook.End()
 
-	mgr := newManager()
+	stopSnapshots := make(chan struct{})
+	defer close(stopSnapshots)
+	go takeSnapshots(mgr, stopSnapshots)
 
 	// Sync clipboard on start in case the monitor was restarted with items in the queue.
 	if err := mgr.SyncClipboard(); err != nil {
@@ -135,25 +190,61 @@ func Start() {
 	}
 
 	log.Println("CBQ monitor started.")
+	if opts.Ephemeral {
+		log.Println("  Ephemeral: the queue is kept in memory only and lost on exit")
+	}
 	log.Println("  Cmd+I  start (clears queue)")
 	log.Println("  Cmd+R  stop  (clears queue)")
-	log.Println("  Cmd+M  toggle queue / stack mode")
-	log.Println("  Cmd+V  paste & advance")
+	log.Println("  Cmd+M  switch mode (queue / stack by default)")
+	log.Println("  Cmd+J  join the queue into one item")
+	if opts.PasteMode == PasteSynthesize {
+		log.Println("  Ctrl+Cmd+V  paste & advance")
+	} else {
+		log.Println("  Cmd+V  paste & advance")
+	}
 	log.Println("  (all clipboard changes captured automatically while active)")
 
 	// If the queue was left active from a previous session, resume polling.
 	var poller *clipboardPoller
 	if state, err := mgr.GetStatus(); err == nil && state.Active {
 		log.Println("Resuming active queue from previous session.")
-		poller = startPoller(mgr)
+		poller = startPoller(mgr, opts.Ephemeral)
 	}
 
-	var (
-		popMu     sync.Mutex
-		isPopping bool
+	paster := newPasteSequencer(opts,
+		func() bool {
+			state, err := mgr.GetStatus()
+			if err != nil {
+				log.Printf("Error reading state: %v", err)
+				return false
+			}
+			if !state.Active || len(state.Items) == 0 {
+				return false
+			}
+			// Ensure the clipboard has the correct item before the OS pastes it.
+			if err := mgr.SyncClipboard(); err != nil {
+				log.Printf("Warning: clipboard sync failed: %v", err)
+			}
+			return true
+		},
+		sendPaste,
+		func() {
+			item, err := mgr.PopAndSync()
+			if err != nil {
+				if err.Error() != "queue is empty" {
+					log.Printf("Error popping: %v", err)
+				}
+				return
+			}
+			log.Printf("Popped: %s", describe(item, opts.Ephemeral))
+		},
 	)
 
 	for ev := range evChan {
+		if ev.Kind == hook.KeyUp && ev.Rawcode == keyV {
+			paster.released()
+			continue
+		}
 		if ev.Kind != hook.KeyDown {
 			continue
 		}
@@ -171,7 +262,7 @@ func Start() {
 			if poller != nil {
 				poller.close()
 			}
-			poller = startPoller(mgr)
+			poller = startPoller(mgr, opts.Ephemeral)
 			log.Println("Queue STARTED")
 			notify("CBQ", "Queue started — recording copies")
 
@@ -187,67 +278,37 @@ func Start() {
 			log.Println("Queue STOPPED")
 			notify("CBQ", "Queue stopped")
 
-		case keyM: // Cmd+M — toggle between queue (FIFO) and stack (LIFO)
-			state, err := mgr.GetStatus()
+		case keyM: // Cmd+M — switch to the next enabled mode
+			mode, err := mgr.NextMode()
 			if err != nil {
-				log.Printf("Error reading state: %v", err)
-				continue
-			}
-			newMode := !state.IsStack
-			if err := mgr.SetStackMode(newMode); err != nil {
 				log.Printf("Error setting mode: %v", err)
 				continue
 			}
-			if err := mgr.SyncClipboard(); err != nil {
-				log.Printf("Warning: clipboard sync failed: %v", err)
-			}
-			label := "Queue (FIFO)"
-			if newMode {
-				label = "Stack (LIFO)"
-			}
-			log.Printf("Mode: %s", label)
-			notify("CBQ", "Mode: "+label)
+			log.Printf("Mode: %s", mode.Label())
+			notify("CBQ", "Mode: "+mode.Label())
 
-		case keyV: // Cmd+V — paste current item and prepare the next
+		case keyJ: // Cmd+J — collapse the queue into a single item
 			state, err := mgr.GetStatus()
 			if err != nil {
 				log.Printf("Error reading state: %v", err)
 				continue
 			}
-			if !state.Active || len(state.Items) == 0 {
+			n := len(state.Items)
+			if !state.Active || n < 2 {
 				continue
 			}
-
-			// Proactively ensure the clipboard has the correct item before the OS pastes it.
-			if err := mgr.SyncClipboard(); err != nil {
-				log.Printf("Warning: clipboard sync failed: %v", err)
+			if _, err := mgr.Join(nil, ""); err != nil {
+				log.Printf("Error joining: %v", err)
+				continue
 			}
+			log.Printf("Joined %d items", n)
+			notify("CBQ", fmt.Sprintf("Joined %d items into one", n))
 
-			popMu.Lock()
-			if isPopping {
-				popMu.Unlock()
+		case keyV: // Cmd+V (or Ctrl+Cmd+V when synthesizing) — paste current item and prepare the next
+			if (ev.Mask&maskCtrl != 0) != (opts.PasteMode == PasteSynthesize) {
 				continue
 			}
-			isPopping = true
-			popMu.Unlock()
-
-			go func() {
-				defer func() {
-					popMu.Lock()
-					isPopping = false
-					popMu.Unlock()
-				}()
-				// Wait for the OS to paste before we put the next item on the clipboard.
-				time.Sleep(50 * time.Millisecond)
-				item, err := mgr.PopAndSync()
-				if err != nil {
-					if err.Error() != "queue is empty" {
-						log.Printf("Error popping: %v", err)
-					}
-					return
-				}
-				log.Printf("Popped: %q", item)
-			}()
+			paster.pressed()
 		}
 	}
 
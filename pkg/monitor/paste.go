@@ -0,0 +1,181 @@
+package monitor
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// PasteMode selects how a paste is sequenced with advancing the queue.
+type PasteMode string
+
+const (
+	// PasteOnKeyUp advances once V is released, then waits PasteDelay.
+	PasteOnKeyUp PasteMode = "keyup"
+	// PasteOnDelay advances PasteDelay after Cmd+V is pressed.
+	PasteOnDelay PasteMode = "delay"
+	// PasteSynthesize leaves plain Cmd+V alone; Ctrl+Cmd+V makes cbq put the
+	// item on the clipboard and send the Cmd+V keystroke itself.
+	PasteSynthesize PasteMode = "synthesize"
+)
+
+// ParsePasteMode validates a paste mode name given on the command line.
+func ParsePasteMode(s string) (PasteMode, error) {
+	switch m := PasteMode(s); m {
+	case PasteOnKeyUp, PasteOnDelay, PasteSynthesize:
+		return m, nil
+	}
+	return "", fmt.Errorf("unknown paste mode %q (want keyup, delay or synthesize)", s)
+}
+
+// Options configures the monitor.
+type Options struct {
+	PasteMode  PasteMode
+	PasteDelay time.Duration
+	// Ephemeral keeps item texts out of the log, which may be a file.
+	Ephemeral bool
+}
+
+// DefaultPasteDelay is how long to give the OS to read the clipboard
+// before the next item replaces it.
+const DefaultPasteDelay = 50 * time.Millisecond
+
+// keyUpTimeout bounds how long a paste waits for V to be released. If the
+// key-up event is lost, the paste already happened on key-down, so the
+// queue is advanced anyway rather than blocking every later Cmd+V.
+const keyUpTimeout = time.Second
+
+type pasteState int
+
+const (
+	pasteIdle      pasteState = iota
+	pasteArmed                // paste key is down, clipboard holds the head item
+	pasteAdvancing            // trigger fired, waiting out the delay before popping
+)
+
+// pasteSequencer is the state machine between a paste shortcut and
+// PopAndSync. Only one paste is in flight at a time: key repeats and
+// events that arrive while a paste is being sequenced are ignored, so a
+// single press can never advance the queue twice.
+type pasteSequencer struct {
+	mode  PasteMode
+	delay time.Duration
+
+	// prepare puts the head item on the clipboard and reports whether
+	// there is anything to paste.
+	prepare func() bool
+	// inject sends the paste keystroke (synthesize mode only).
+	inject func() error
+	// advance pops the pasted item and syncs the next one.
+	advance func()
+	// after schedules f; time.AfterFunc outside of tests.
+	after func(d time.Duration, f func())
+	// logf reports non-fatal errors.
+	logf func(format string, args ...any)
+
+	mu    sync.Mutex
+	state pasteState
+	gen   int // bumped per paste so stale timers are ignored
+}
+
+func newPasteSequencer(opts Options, prepare func() bool, inject func() error, advance func()) *pasteSequencer {
+	return &pasteSequencer{
+		mode:    opts.PasteMode,
+		delay:   opts.PasteDelay,
+		prepare: prepare,
+		inject:  inject,
+		advance: advance,
+		after: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+		logf: log.Printf,
+	}
+}
+
+// pressed handles key-down of the paste shortcut.
+func (p *pasteSequencer) pressed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != pasteIdle {
+		return // key repeat or a second press mid-sequence
+	}
+	if !p.prepare() {
+		return
+	}
+	p.gen++
+	gen := p.gen
+
+	if p.mode == PasteOnDelay {
+		p.state = pasteAdvancing
+		p.after(p.delay, func() { p.finish(gen) })
+		return
+	}
+	p.state = pasteArmed
+	p.after(keyUpTimeout, func() { p.timeout(gen) })
+}
+
+// released handles key-up of V.
+func (p *pasteSequencer) released() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.state != pasteArmed {
+		return
+	}
+	p.state = pasteAdvancing
+	gen := p.gen
+
+	if p.mode == PasteSynthesize {
+		// Give the user a moment to let go of the modifiers so they don't
+		// combine with the injected Cmd+V.
+		p.after(p.delay, func() {
+			if err := p.inject(); err != nil {
+				p.logf("Error sending paste keystroke: %v", err)
+				p.reset(gen)
+				return
+			}
+			p.after(p.delay, func() { p.finish(gen) })
+		})
+		return
+	}
+	p.after(p.delay, func() { p.finish(gen) })
+}
+
+// timeout advances a paste whose key-up never arrived.
+func (p *pasteSequencer) timeout(gen int) {
+	p.mu.Lock()
+	if p.state != pasteArmed || p.gen != gen {
+		p.mu.Unlock()
+		return
+	}
+	p.state = pasteAdvancing
+	p.mu.Unlock()
+
+	if p.mode == PasteSynthesize {
+		p.reset(gen) // nothing was pasted yet, so don't consume the item
+		return
+	}
+	p.finish(gen)
+}
+
+func (p *pasteSequencer) finish(gen int) {
+	p.mu.Lock()
+	if p.state != pasteAdvancing || p.gen != gen {
+		p.mu.Unlock()
+		return
+	}
+	p.mu.Unlock()
+
+	p.advance()
+	p.reset(gen)
+}
+
+func (p *pasteSequencer) reset(gen int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.gen == gen {
+		p.state = pasteIdle
+	}
+}
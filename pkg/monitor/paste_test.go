@@ -0,0 +1,164 @@
+package monitor
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeTimers records scheduled callbacks so tests can fire them explicitly.
+type fakeTimers struct {
+	pending []func()
+	delays  []time.Duration
+}
+
+func (f *fakeTimers) after(d time.Duration, fn func()) {
+	f.pending = append(f.pending, fn)
+	f.delays = append(f.delays, d)
+}
+
+// fire runs the i-th scheduled callback.
+func (f *fakeTimers) fire(i int) {
+	f.pending[i]()
+}
+
+type pasteHarness struct {
+	seq      *pasteSequencer
+	timers   *fakeTimers
+	ready    bool
+	prepared int
+	injected int
+	advanced int
+}
+
+func newPasteHarness(mode PasteMode) *pasteHarness {
+	h := &pasteHarness{timers: &fakeTimers{}, ready: true}
+	h.seq = newPasteSequencer(Options{PasteMode: mode, PasteDelay: 10 * time.Millisecond},
+		func() bool { h.prepared++; return h.ready },
+		func() error { h.injected++; return nil },
+		func() { h.advanced++ },
+	)
+	h.seq.after = h.timers.after
+	h.seq.logf = func(string, ...any) {}
+	return h
+}
+
+func TestPasteSequencer_KeyUp(t *testing.T) {
+	h := newPasteHarness(PasteOnKeyUp)
+
+	h.seq.pressed()
+	h.seq.pressed() // key repeat
+	if h.prepared != 1 {
+		t.Fatalf("expected one prepare, got %d", h.prepared)
+	}
+	if h.advanced != 0 {
+		t.Fatal("advanced before key-up")
+	}
+
+	h.seq.released()
+	if len(h.timers.pending) != 2 || h.timers.delays[1] != 10*time.Millisecond {
+		t.Fatalf("expected delay timer after key-up, got %v", h.timers.delays)
+	}
+	h.timers.fire(1)
+	if h.advanced != 1 {
+		t.Errorf("expected one advance, got %d", h.advanced)
+	}
+
+	// The key-up timeout from the finished paste must not advance again.
+	h.timers.fire(0)
+	if h.advanced != 1 {
+		t.Errorf("stale timeout advanced the queue")
+	}
+
+	// A new press starts a fresh sequence.
+	h.seq.pressed()
+	if h.prepared != 2 {
+		t.Errorf("expected second paste to be prepared")
+	}
+}
+
+func TestPasteSequencer_KeyUpTimeout(t *testing.T) {
+	h := newPasteHarness(PasteOnKeyUp)
+
+	h.seq.pressed()
+	h.timers.fire(0) // key-up was lost
+	if h.advanced != 1 {
+		t.Fatalf("expected timeout to advance, got %d", h.advanced)
+	}
+
+	// A late key-up is ignored.
+	h.seq.released()
+	if len(h.timers.pending) != 1 {
+		t.Errorf("late key-up scheduled another advance")
+	}
+}
+
+func TestPasteSequencer_Delay(t *testing.T) {
+	h := newPasteHarness(PasteOnDelay)
+
+	h.seq.pressed()
+	h.seq.pressed()
+	h.seq.released()
+	if len(h.timers.pending) != 1 {
+		t.Fatalf("expected a single delay timer, got %d", len(h.timers.pending))
+	}
+	h.timers.fire(0)
+	if h.advanced != 1 {
+		t.Errorf("expected one advance, got %d", h.advanced)
+	}
+}
+
+func TestPasteSequencer_NothingToPaste(t *testing.T) {
+	h := newPasteHarness(PasteOnKeyUp)
+	h.ready = false
+
+	h.seq.pressed()
+	h.seq.released()
+	if len(h.timers.pending) != 0 || h.advanced != 0 {
+		t.Errorf("inactive queue should not start a paste")
+	}
+}
+
+func TestPasteSequencer_Synthesize(t *testing.T) {
+	h := newPasteHarness(PasteSynthesize)
+
+	h.seq.pressed()
+	h.seq.released()
+	h.timers.fire(1) // modifiers released, inject
+	if h.injected != 1 {
+		t.Fatalf("expected one injected paste, got %d", h.injected)
+	}
+	if h.advanced != 0 {
+		t.Fatal("advanced before the injected paste was consumed")
+	}
+	h.timers.fire(2)
+	if h.advanced != 1 {
+		t.Errorf("expected one advance, got %d", h.advanced)
+	}
+}
+
+func TestPasteSequencer_SynthesizeFailure(t *testing.T) {
+	h := newPasteHarness(PasteSynthesize)
+	h.seq.inject = func() error { return errors.New("not allowed") }
+
+	h.seq.pressed()
+	h.seq.released()
+	h.timers.fire(1)
+	if h.advanced != 0 {
+		t.Error("failed injection must not consume the item")
+	}
+
+	h.seq.pressed()
+	if h.prepared != 2 {
+		t.Error("sequencer did not return to idle after a failed injection")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	if got := describe("secret", false); got != `"secret"` {
+		t.Errorf("got %s", got)
+	}
+	if got := describe("sécret", true); got != "6 characters" {
+		t.Errorf("got %s, want only the length", got)
+	}
+}
@@ -0,0 +1,93 @@
+package queue
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+// ErrNoSnapshots is returned by the snapshot methods if the Manager keeps
+// no snapshots.
+var ErrNoSnapshots = errors.New("backups are turned off")
+
+// UseSnapshots has the Manager keep snapshots of the state in s whenever
+// the queue is started or stopped, and on TakeSnapshot.
+func (m *Manager) UseSnapshots(s *storage.Snapshots) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots = s
+}
+
+// snapshot stores a copy of state, if snapshots are kept. A failure is
+// logged rather than returned: a full disk or unwritable backup directory
+// should not keep the queue from being started, stopped or restored.
+// Must be called with m.mu held.
+func (m *Manager) snapshot(state *storage.State, reason string) {
+	if m.snapshots == nil {
+		return
+	}
+	if _, err := m.snapshots.Take(state, reason, time.Now()); err != nil {
+		log.Printf("backing up state: %v", err)
+	}
+}
+
+// TakeSnapshot stores a copy of the current state, unless it equals the
+// newest snapshot, and returns the snapshot holding it.
+func (m *Manager) TakeSnapshot(reason string) (storage.Snapshot, error) {
+	m.lock()
+	defer m.unlock()
+
+	if m.snapshots == nil {
+		return storage.Snapshot{}, ErrNoSnapshots
+	}
+	state, err := m.load()
+	if err != nil {
+		return storage.Snapshot{}, err
+	}
+	return m.snapshots.Take(state, reason, time.Now())
+}
+
+// Snapshots lists the stored snapshots, newest first.
+func (m *Manager) Snapshots() ([]storage.Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.snapshots == nil {
+		return nil, ErrNoSnapshots
+	}
+	return m.snapshots.List()
+}
+
+// Restore replaces the state with the one in the named snapshot (see
+// storage.Snapshots.Load) and syncs the clipboard if the restored queue is
+// active. The state being replaced is snapshotted first, so a restore can
+// be undone. The history is kept, as items pasted since the snapshot are
+// not queued again.
+func (m *Manager) Restore(name string) (storage.Snapshot, error) {
+	m.lock()
+	defer m.unlock()
+
+	if m.snapshots == nil {
+		return storage.Snapshot{}, ErrNoSnapshots
+	}
+	restored, snap, err := m.snapshots.Load(name)
+	if err != nil {
+		return storage.Snapshot{}, err
+	}
+	state, err := m.load()
+	if err != nil {
+		return storage.Snapshot{}, err
+	}
+	m.snapshot(state, "restore")
+	prev := *state
+	*state = *restored
+	state.History = prev.History
+	if err := m.save(state, func() { *state = prev }); err != nil {
+		return storage.Snapshot{}, err
+	}
+	if state.Active {
+		return snap, m.sync(state)
+	}
+	return snap, nil
+}
@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+func TestManager_Restore(t *testing.T) {
+	s := newTestStorage(&storage.State{Items: items("a", "b"), Active: true})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+	if _, err := mgr.Snapshots(); !errors.Is(err, ErrNoSnapshots) {
+		t.Errorf("expected ErrNoSnapshots, got %v", err)
+	}
+	mgr.UseSnapshots(storage.NewSnapshots(t.TempDir(), 10))
+
+	// Stopping the queue empties it, but keeps a snapshot.
+	if err := mgr.SetActive(false); err != nil {
+		t.Fatal(err)
+	}
+	list, err := mgr.Snapshots()
+	if err != nil || len(list) != 1 || list[0].Reason != "deactivate" || list[0].Items != 2 {
+		t.Fatalf("unexpected snapshots %+v: %v", list, err)
+	}
+
+	if _, err := mgr.Restore(list[0].Name); err != nil {
+		t.Fatal(err)
+	}
+	state, _ := mgr.GetStatus()
+	if !state.Active || len(state.Items) != 2 || state.Items[0].Text != "a" {
+		t.Errorf("expected the queue restored, got %+v", state)
+	}
+	if c.content != "a" {
+		t.Errorf("expected the clipboard re-synced, got %q", c.content)
+	}
+
+	// The emptied queue was snapshotted before the restore.
+	list, _ = mgr.Snapshots()
+	if len(list) != 2 || list[0].Reason != "restore" || list[0].Items != 0 {
+		t.Errorf("expected a snapshot of the replaced state, got %+v", list)
+	}
+	if snap, err := mgr.TakeSnapshot("manual"); err != nil || snap.Items != 2 {
+		t.Errorf("got %+v: %v", snap, err)
+	}
+}
+
+// A snapshot that cannot be stored doesn't keep the queue from being
+// started or restored.
+func TestManager_SnapshotFails(t *testing.T) {
+	dir := t.TempDir()
+	mgr := NewManager(newTestStorage(&storage.State{Items: items("a", "b"), Active: true}), &MockClipboard{})
+	mgr.UseSnapshots(storage.NewSnapshots(dir, 10))
+	if err := mgr.SetActive(false); err != nil {
+		t.Fatal(err)
+	}
+	list, err := mgr.Snapshots()
+	if err != nil || len(list) != 1 {
+		t.Fatalf("unexpected snapshots %+v: %v", list, err)
+	}
+
+	if err := os.Chmod(dir, 0o500); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chmod(dir, 0o700) })
+	if err := os.WriteFile(filepath.Join(dir, "probe"), nil, 0o600); err == nil {
+		t.Skip("the snapshot directory is still writable, as when running as root")
+	}
+
+	if err := mgr.SetActive(true); err != nil {
+		t.Errorf("SetActive: %v", err)
+	}
+	if _, err := mgr.Restore(list[0].Name); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	state, _ := mgr.GetStatus()
+	if len(state.Items) != 2 || !state.Active {
+		t.Errorf("expected the queue restored, got %+v", state)
+	}
+}
@@ -0,0 +1,50 @@
+package queue
+
+import (
+	"slices"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+// HistoryLimit is how many pasted items the history keeps.
+const HistoryLimit = 100
+
+// remember returns history with items appended, dropping the oldest items
+// beyond HistoryLimit. history itself is left unchanged for rollback.
+func remember(history []storage.Item, items ...storage.Item) []storage.Item {
+	history = append(slices.Clone(history), items...)
+	if over := len(history) - HistoryLimit; over > 0 {
+		history = slices.Clone(history[over:])
+	}
+	return history
+}
+
+// AddHistory appends texts to the history with a single save, e.g. when
+// importing the history of another machine.
+func (m *Manager) AddHistory(texts []string) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	prev := state.History
+	state.History = remember(state.History, newItems(texts)...)
+	return m.save(state, func() { state.History = prev })
+}
+
+// ReplaceHistory discards the history and keeps texts instead; no texts
+// clears it.
+func (m *Manager) ReplaceHistory(texts []string) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	prev := state.History
+	state.History = remember(nil, newItems(texts)...)
+	return m.save(state, func() { state.History = prev })
+}
@@ -0,0 +1,68 @@
+package queue
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+func TestManager_History(t *testing.T) {
+	s := newTestStorage(&storage.State{Items: items("a", "b", "c"), Active: true})
+	mgr := NewManager(s, &MockClipboard{})
+
+	for range 2 {
+		if _, err := mgr.PopAndSync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := storage.Texts(s.state().History); !slices.Equal(got, []string{"a", "b"}) {
+		t.Fatalf("expected the pasted items in the history, got %q", got)
+	}
+
+	// Clearing the queue keeps the history.
+	if err := mgr.SetActive(false); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.AddHistory([]string{"imported"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.Texts(s.state().History); !slices.Equal(got, []string{"a", "b", "imported"}) {
+		t.Errorf("expected the history kept and extended, got %q", got)
+	}
+
+	if err := mgr.ReplaceHistory(nil); err != nil {
+		t.Fatal(err)
+	}
+	if h := s.state().History; len(h) != 0 {
+		t.Errorf("expected the history cleared, got %v", h)
+	}
+}
+
+func TestManager_HistoryLimit(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true})
+	mgr := NewManager(s, &MockClipboard{})
+	texts := make([]string, HistoryLimit+5)
+	for i := range texts {
+		texts[i] = strconv.Itoa(i)
+	}
+	if err := mgr.AddHistory(texts); err != nil {
+		t.Fatal(err)
+	}
+	h := s.state().History
+	if len(h) != HistoryLimit || h[0].Text != "5" || h[len(h)-1].Text != texts[len(texts)-1] {
+		t.Errorf("expected the newest %d items, got %d from %q", HistoryLimit, len(h), h[0].Text)
+	}
+}
+
+func TestManager_HistoryCycle(t *testing.T) {
+	s := newTestStorage(&storage.State{Items: items("a", "b"), Active: true, Cycle: true})
+	mgr := NewManager(s, &MockClipboard{})
+	if _, err := mgr.PopAndSync(); err != nil {
+		t.Fatal(err)
+	}
+	if h := s.state().History; len(h) != 0 {
+		t.Errorf("expected items a cycling queue keeps to stay out of the history, got %v", h)
+	}
+}
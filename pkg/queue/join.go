@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// DefaultJoinSeparator is used when neither the caller nor the queue
+// configures how items are joined.
+const DefaultJoinSeparator = `\n`
+
+var separatorEscapes = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\r`, "\r", `\\`, `\`)
+
+// joinTexts combines texts into one. spec is either a separator, in which
+// \n, \t, \r and \\ are unescaped so they can be typed on a command line,
+// or, if it contains "{{", a text/template executed with .Items.
+func joinTexts(texts []string, spec string) (string, error) {
+	if !strings.Contains(spec, "{{") {
+		return strings.Join(texts, separatorEscapes.Replace(spec)), nil
+	}
+	tmpl, err := template.New("join").Parse(spec)
+	if err != nil {
+		return "", fmt.Errorf("invalid join template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, struct{ Items []string }{texts}); err != nil {
+		return "", fmt.Errorf("join template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// ValidateJoin reports whether spec is a usable separator or template.
+func ValidateJoin(spec string) error {
+	_, err := joinTexts([]string{"a", "b"}, spec)
+	return err
+}
@@ -0,0 +1,22 @@
+package queue
+
+import "testing"
+
+func TestJoinTexts(t *testing.T) {
+	cases := []struct {
+		spec, want string
+	}{
+		{", ", "a, b"},
+		{`\n\t\\`, "a\n\t\\b"},
+		{"{{len .Items}}", "2"},
+	}
+	for _, tc := range cases {
+		got, err := joinTexts([]string{"a", "b"}, tc.spec)
+		if err != nil || got != tc.want {
+			t.Errorf("%q: got %q, %v; want %q", tc.spec, got, err, tc.want)
+		}
+	}
+	if err := ValidateJoin("{{end}}"); err == nil {
+		t.Error("expected error for invalid template")
+	}
+}
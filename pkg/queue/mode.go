@@ -0,0 +1,130 @@
+package queue
+
+import (
+	"cmp"
+	"math/rand/v2"
+	"slices"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+// NextIndex returns the index of the item that will be pasted next, or -1
+// if the queue is empty.
+func NextIndex(state *storage.State) int {
+	n := len(state.Items)
+	if n == 0 {
+		return -1
+	}
+	if state.Mode == storage.ModeShuffle {
+		return shuffledIndex(state)
+	}
+	pos := 0
+	if state.Cycle {
+		pos = state.Cursor % n
+	}
+	if state.Mode != storage.ModePriority && !hasPinned(state.Items) {
+		// The common case, without building the whole sequence.
+		if state.Mode == storage.ModeStack {
+			return n - 1 - pos
+		}
+		return pos
+	}
+	return pasteSequence(state)[pos]
+}
+
+// shuffledIndex draws the next item of a shuffled queue. Each draw is
+// derived from the seed and the number of draws so far, so the item on the
+// clipboard is the one that gets popped. Pinned items keep their position
+// unless the queue cycles, where every draw is from all items.
+func shuffledIndex(state *storage.State) int {
+	seq := pasteSequence(state)
+	r := rand.New(rand.NewPCG(state.Seed, uint64(state.Cursor)))
+	if state.Cycle {
+		return seq[r.IntN(len(seq))]
+	}
+	pinned := 0
+	for _, item := range state.Items {
+		if item.Pinned {
+			pinned++
+		}
+	}
+	rest := len(seq) - pinned
+	if pinned > 0 && state.PinPosition != storage.PinLast || rest == 0 {
+		return seq[0] // a pinned item is due
+	}
+	return seq[r.IntN(rest)]
+}
+
+// pasteSequence returns the index of every item in the order they will be
+// pasted, with pinned items first or last. A shuffled queue has no fixed
+// order; its unpinned items are listed in copy order.
+func pasteSequence(state *storage.State) []int {
+	var pinned, rest []int
+	for i, item := range state.Items {
+		if item.Pinned {
+			pinned = append(pinned, i)
+		} else {
+			rest = append(rest, i)
+		}
+	}
+	for _, seq := range [][]int{pinned, rest} {
+		switch state.Mode {
+		case storage.ModeStack:
+			slices.Reverse(seq)
+		case storage.ModePriority:
+			slices.SortStableFunc(seq, func(a, b int) int {
+				return cmp.Compare(state.Items[b].Priority, state.Items[a].Priority)
+			})
+		}
+	}
+	if state.PinPosition == storage.PinLast {
+		return append(rest, pinned...)
+	}
+	return append(pinned, rest...)
+}
+
+// followCursor moves the cursor of a cycling queue after its items changed
+// from old, so that the item pasted next stays the same, or becomes the
+// first one after it still there if it was removed. Items inserted right
+// before that item are pasted next, as they would be without cycling.
+// Shuffled queues count draws in the cursor and are left alone.
+func followCursor(state *storage.State, old []storage.Item) {
+	if !state.Cycle || state.Mode == storage.ModeShuffle {
+		return
+	}
+	n := len(state.Items)
+	if n == 0 {
+		state.Cursor = 0
+		return
+	}
+	seq := pasteSequence(state)
+	pos := make(map[string]int, n)
+	for p, i := range seq {
+		pos[state.Items[i].ID] = p
+	}
+	existed := make(map[string]bool, len(old))
+	for _, item := range old {
+		existed[item.ID] = true
+	}
+
+	cursor := n // wraps around if nothing from the old cursor on is left
+	if len(old) > 0 {
+		prev := *state
+		prev.Items = old
+		oldSeq := pasteSequence(&prev)
+		for _, i := range oldSeq[state.Cursor%len(old):] {
+			if p, ok := pos[old[i].ID]; ok {
+				cursor = p
+				break
+			}
+		}
+	}
+	for cursor > 0 && !existed[state.Items[seq[cursor-1]].ID] {
+		cursor--
+	}
+	state.Cursor = cursor % n
+}
+
+func hasPinned(items []storage.Item) bool {
+	return slices.ContainsFunc(items, func(it storage.Item) bool { return it.Pinned })
+}
@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+func TestPasteSequence(t *testing.T) {
+	list := items("a", "b", "c", "d")
+	list[1].Priority = 5
+	list[2].Pinned = true
+	list[3].Priority = 5
+
+	cases := []struct {
+		mode storage.Mode
+		pins storage.PinPosition
+		want []int
+	}{
+		{storage.ModeQueue, "", []int{2, 0, 1, 3}},
+		{"", storage.PinFirst, []int{2, 0, 1, 3}},
+		{storage.ModeQueue, storage.PinLast, []int{0, 1, 3, 2}},
+		{storage.ModeStack, "", []int{2, 3, 1, 0}},
+		{storage.ModePriority, "", []int{2, 1, 3, 0}},
+		{storage.ModePriority, storage.PinLast, []int{1, 3, 0, 2}},
+		{storage.ModeShuffle, "", []int{2, 0, 1, 3}},
+	}
+	for _, tc := range cases {
+		state := &storage.State{Items: list, Mode: tc.mode, PinPosition: tc.pins}
+		if got := pasteSequence(state); !slices.Equal(got, tc.want) {
+			t.Errorf("%q, pins %q: got %v, want %v", tc.mode, tc.pins, got, tc.want)
+		}
+		if tc.mode != storage.ModeShuffle && NextIndex(state) != tc.want[0] {
+			t.Errorf("%q, pins %q: NextIndex = %d, want %d", tc.mode, tc.pins, NextIndex(state), tc.want[0])
+		}
+	}
+	if i := NextIndex(&storage.State{Items: list, Mode: storage.ModeShuffle}); i != 2 {
+		t.Errorf("expected the pinned item first in shuffle mode, got %d", i)
+	}
+	if NextIndex(&storage.State{}) != -1 {
+		t.Error("expected -1 for an empty queue")
+	}
+}
+
+func TestManager_PriorityMode(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("low", "high", "mid")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if err := mgr.SetMode(storage.ModePriority); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetPriority(s.state().Items[1].ID, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetPriority(s.state().Items[2].ID, 5); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "high" {
+		t.Errorf("expected highest priority on clipboard, got %q", c.content)
+	}
+	var pasted []string
+	for range 3 {
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pasted = append(pasted, item)
+	}
+	if want := []string{"high", "mid", "low"}; !slices.Equal(pasted, want) {
+		t.Errorf("got %v, want %v", pasted, want)
+	}
+}
+
+func TestManager_ShuffleMode(t *testing.T) {
+	texts := []string{"a", "b", "c", "d", "e", "f"}
+	s := newTestStorage(&storage.State{Active: true, Items: items(texts...)})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if err := mgr.SetMode(storage.ModeShuffle); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SyncClipboard(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var pasted []string
+	for range texts {
+		onClipboard := c.content
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if item != onClipboard {
+			t.Errorf("popped %q but %q was on the clipboard", item, onClipboard)
+		}
+		pasted = append(pasted, item)
+	}
+	slices.Sort(pasted)
+	if !slices.Equal(pasted, texts) {
+		t.Errorf("expected every item exactly once, got %v", pasted)
+	}
+}
+
+func TestManager_NextMode(t *testing.T) {
+	s := newTestStorage(&storage.State{Mode: storage.ModeQueue, Items: items("a", "b")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	// By default Cmd+M toggles between queue and stack, re-syncing the
+	// clipboard.
+	for _, want := range []struct {
+		mode storage.Mode
+		next string
+	}{{storage.ModeStack, "b"}, {storage.ModeQueue, "a"}} {
+		if mode, err := mgr.NextMode(); err != nil || mode != want.mode {
+			t.Errorf("expected %q, got %q: %v", want.mode, mode, err)
+		}
+		if c.content != want.next {
+			t.Errorf("expected clipboard=%s in %s mode, got %q", want.next, want.mode, c.content)
+		}
+	}
+
+	enabled := []storage.Mode{storage.ModeQueue, storage.ModePriority, storage.ModeShuffle}
+	if err := mgr.SetEnabledModes(enabled); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []storage.Mode{storage.ModePriority, storage.ModeShuffle, storage.ModeQueue} {
+		if mode, err := mgr.NextMode(); err != nil || mode != want {
+			t.Errorf("expected %q, got %q: %v", want, mode, err)
+		}
+	}
+
+	// A mode outside the rotation moves to the first enabled one.
+	if err := mgr.SetMode(storage.ModeStack); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mode, _ := mgr.NextMode(); mode != storage.ModeQueue {
+		t.Errorf("expected queue, got %q", mode)
+	}
+
+	if err := mgr.SetEnabledModes(nil); err == nil {
+		t.Error("expected error for no modes")
+	}
+	if err := mgr.SetMode("lifo"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
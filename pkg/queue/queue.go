@@ -2,9 +2,18 @@ package queue
 
 import (
 	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/matouschdavid/Clipboard-queue/pkg/generator"
 	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+	"github.com/matouschdavid/Clipboard-queue/pkg/transform"
 )
 
 // Clipboard interface allows mocking the system clipboard for tests.
@@ -13,12 +22,27 @@ type Clipboard interface {
 	Write(text string) error
 }
 
+// ErrConflict is returned when an item changed or disappeared between
+// reading it and writing an edit back.
+var ErrConflict = errors.New("item changed in the meantime")
+
 // Manager handles the core business logic of the clipboard queue.
+//
+// Every method is a transaction: with a storage.Locker, such as
+// JSONStorage, it holds the storage lock and reads the state afresh if it
+// changed, so the monitor and CLI processes can change the same queue
+// concurrently.
 type Manager struct {
-	storage   storage.Storage
-	clipboard Clipboard
-	mu        sync.Mutex
-	state     *storage.State
+	storage    storage.Storage
+	clipboard  Clipboard
+	mu         sync.Mutex
+	state      *storage.State
+	written    string // last value sync put on the clipboard
+	syncedID   string // ID and text of the item written was rendered from
+	syncedText string
+	unlockFile func() // releases the storage lock, if held
+	lockErr    error  // from lock, reported by load
+	snapshots  *storage.Snapshots
 }
 
 func NewManager(s storage.Storage, c Clipboard) *Manager {
@@ -28,9 +52,55 @@ func NewManager(s storage.Storage, c Clipboard) *Manager {
 	}
 }
 
+// lock serializes access to the state: within the process through m.mu,
+// and across processes through the storage lock, if the storage has one.
+// The cached state is dropped if another process may have changed it.
+func (m *Manager) lock() {
+	m.mu.Lock()
+	if l, ok := m.storage.(storage.Locker); ok {
+		if m.unlockFile, m.lockErr = l.Lock(); m.lockErr != nil {
+			m.lockErr = fmt.Errorf("locking state: %w", m.lockErr)
+			return
+		}
+	}
+	m.lockErr = m.dropStale()
+}
+
+// dropStale drops the cached state if the storage reports a change, or if
+// it is shared with other processes and cannot tell.
+// Must be called with m.mu held.
+func (m *Manager) dropStale() error {
+	switch s := m.storage.(type) {
+	case storage.ChangeDetector:
+		changed, err := s.Changed()
+		if err != nil {
+			return err
+		}
+		if changed {
+			m.state = nil
+		}
+	case storage.Locker:
+		m.state = nil
+	}
+	return nil
+}
+
+// unlock releases what lock acquired.
+func (m *Manager) unlock() {
+	if m.unlockFile != nil {
+		m.unlockFile()
+		m.unlockFile = nil
+	}
+	m.lockErr = nil
+	m.mu.Unlock()
+}
+
 // load returns the cached state, loading from storage if needed.
 // Must be called with m.mu held.
 func (m *Manager) load() (*storage.State, error) {
+	if m.lockErr != nil {
+		return nil, m.lockErr
+	}
 	if m.state != nil {
 		return m.state, nil
 	}
@@ -55,8 +125,8 @@ func (m *Manager) save(state *storage.State, rollback func()) error {
 
 // Add appends a new item to the queue if it's active.
 func (m *Manager) Add(item string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.lock()
+	defer m.unlock()
 
 	state, err := m.load()
 	if err != nil {
@@ -65,18 +135,20 @@ func (m *Manager) Add(item string) error {
 	if !state.Active {
 		return nil
 	}
-	if len(state.Items) > 0 && state.Items[len(state.Items)-1] == item {
+	if len(state.Items) > 0 && state.Items[len(state.Items)-1].Text == item {
 		return nil // deduplicate consecutive copies
 	}
 
-	state.Items = append(state.Items, item)
-	return m.save(state, func() { state.Items = state.Items[:len(state.Items)-1] })
+	prev, prevCursor := state.Items, state.Cursor
+	state.Items = append(slices.Clone(state.Items), storage.NewItem(item))
+	followCursor(state, prev)
+	return m.save(state, func() { state.Items, state.Cursor = prev, prevCursor })
 }
 
 // AddAndSync appends an item and updates the clipboard in one atomic operation.
 func (m *Manager) AddAndSync(item string) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.lock()
+	defer m.unlock()
 
 	state, err := m.load()
 	if err != nil {
@@ -85,42 +157,381 @@ func (m *Manager) AddAndSync(item string) error {
 	if !state.Active {
 		return nil
 	}
-	if len(state.Items) > 0 && state.Items[len(state.Items)-1] == item {
+	if len(state.Items) > 0 && state.Items[len(state.Items)-1].Text == item {
 		return nil // deduplicate consecutive copies
 	}
 
-	state.Items = append(state.Items, item)
-	if err := m.save(state, func() { state.Items = state.Items[:len(state.Items)-1] }); err != nil {
+	prev, prevCursor := state.Items, state.Cursor
+	state.Items = append(slices.Clone(state.Items), storage.NewItem(item))
+	followCursor(state, prev)
+	if err := m.save(state, func() { state.Items, state.Cursor = prev, prevCursor }); err != nil {
 		return err
 	}
 	return m.sync(state)
 }
 
-// Pop removes an item from the queue (LIFO if isStack, else FIFO).
-func (m *Manager) Pop(isStack bool) (string, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// Capture adds text copied by the user and syncs the clipboard. With split
+// mode on, the text is split into several items that paste in their
+// original order in both queue and stack mode. The capture transformations
+// are applied to each item; items they leave empty are dropped.
+func (m *Manager) Capture(text string) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	if !state.Active || state.Generator != "" {
+		return nil // generator queues make their own items
+	}
+	parts := []string{text}
+	if state.Split != "" {
+		splitter, err := ParseSplitter(state.Split)
+		if err != nil {
+			return err
+		}
+		parts = splitter.Split(text)
+	}
+	if chain := transform.Chain(state.CaptureTransforms); len(chain) > 0 {
+		transformed := parts[:0:0]
+		for _, part := range parts {
+			if part = chain.Apply(part); part != "" {
+				transformed = append(transformed, part)
+			}
+		}
+		parts = transformed
+	}
+	if len(parts) == 0 {
+		return nil
+	}
+	if len(parts) == 1 && len(state.Items) > 0 && state.Items[len(state.Items)-1].Text == parts[0] {
+		return nil // deduplicate consecutive copies
+	}
+
+	prev, prevCursor := state.Items, state.Cursor
+	state.Items = append(slices.Clone(state.Items), pasteOrder(state, newItems(parts))...)
+	followCursor(state, prev)
+	if err := m.save(state, func() { state.Items, state.Cursor = prev, prevCursor }); err != nil {
+		return err
+	}
+	return m.sync(state)
+}
+
+// SetSplitMode sets the delimiter spec captured text is split on (see
+// ParseSplitter); an empty spec turns split mode off.
+func (m *Manager) SetSplitMode(spec string) error {
+	if spec != "" {
+		if _, err := ParseSplitter(spec); err != nil {
+			return err
+		}
+	}
+
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	prev := state.Split
+	state.Split = spec
+	return m.save(state, func() { state.Split = prev })
+}
+
+// SplitAt replaces the item at index with its parts, in place, so they
+// paste in their original order. It returns the number of parts.
+func (m *Manager) SplitAt(index int, splitter Splitter) (int, error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return 0, err
+	}
+	if err := checkIndex(index, len(state.Items)); err != nil {
+		return 0, err
+	}
+	parts := splitter.Split(state.Items[index].Text)
+	if len(parts) <= 1 {
+		return len(parts), nil // nothing to split
+	}
+	items := slices.Delete(slices.Clone(state.Items), index, index+1)
+	items = slices.Insert(items, index, pasteOrder(state, newItems(parts))...)
+	if err := m.replaceItems(state, items); err != nil {
+		return 0, err
+	}
+	return len(parts), nil
+}
+
+// Join collapses the items at indices (all items if none are given) into a
+// single item, joined in paste order, placed where the first of them would
+// have been pasted. separator is a separator or template as described for
+// SetJoinSeparator; if empty, the queue's configured one is used. It returns
+// the joined text.
+func (m *Manager) Join(indices []int, separator string) (string, error) {
+	m.lock()
+	defer m.unlock()
 
 	state, err := m.load()
 	if err != nil {
 		return "", err
 	}
-	if len(state.Items) == 0 {
+	if len(indices) == 0 {
+		for i := range state.Items {
+			indices = append(indices, i)
+		}
+	}
+	if len(indices) == 0 {
 		return "", errors.New("queue is empty")
 	}
+	selected := make(map[int]bool, len(indices))
+	for _, i := range indices {
+		if err := checkIndex(i, len(state.Items)); err != nil {
+			return "", err
+		}
+		selected[i] = true
+	}
+
+	// Collect the selection in paste order.
+	order := make([]int, 0, len(selected))
+	for _, i := range pasteSequence(state) {
+		if selected[i] {
+			order = append(order, i)
+		}
+	}
+	texts := make([]string, len(order))
+	for n, i := range order {
+		texts[n] = state.Items[i].Text
+	}
+
+	if separator == "" {
+		separator = state.JoinWith
+	}
+	if separator == "" {
+		separator = DefaultJoinSeparator
+	}
+	joined, err := joinTexts(texts, separator)
+	if err != nil {
+		return "", err
+	}
+
+	items := make([]storage.Item, 0, len(state.Items)-len(order)+1)
+	for i, item := range state.Items {
+		switch {
+		case i == order[0]:
+			items = append(items, storage.NewItem(joined))
+		case !selected[i]:
+			items = append(items, item)
+		}
+	}
+	if err := m.replaceItems(state, items); err != nil {
+		return "", err
+	}
+	return joined, nil
+}
+
+// SetJoinSeparator configures how Join combines items: a separator, in
+// which \n, \t, \r and \\ are unescaped, or a text/template over .Items
+// if it contains "{{". An empty value restores the default newline.
+func (m *Manager) SetJoinSeparator(separator string) error {
+	if err := ValidateJoin(separator); err != nil {
+		return err
+	}
+
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	prev := state.JoinWith
+	state.JoinWith = separator
+	return m.save(state, func() { state.JoinWith = prev })
+}
+
+// SetTransforms sets the transformations applied at capture time (paste
+// false) or when an item is put on the clipboard (paste true). An empty
+// chain turns transformation off for that stage.
+func (m *Manager) SetTransforms(paste bool, chain transform.Chain) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	field := &state.CaptureTransforms
+	if paste {
+		field = &state.PasteTransforms
+	}
+	prev := *field
+	*field = slices.Clone(chain)
+	if err := m.save(state, func() { *field = prev }); err != nil {
+		return err
+	}
+	if paste {
+		return m.sync(state) // re-render the item on the clipboard
+	}
+	return nil
+}
+
+// SetTemplate sets the text/template items are rendered with before they
+// are put on the clipboard (see PasteData); an empty template pastes items
+// as they are.
+func (m *Manager) SetTemplate(spec string) error {
+	if spec != "" {
+		if err := ValidateTemplate(spec); err != nil {
+			return err
+		}
+	}
+
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	prev := state.Template
+	state.Template = spec
+	if err := m.save(state, func() { state.Template = prev }); err != nil {
+		return err
+	}
+	return m.sync(state)
+}
+
+// SetMeta sets a metadata key on the item with the given ID; an empty value
+// removes the key.
+func (m *Manager) SetMeta(id, key, value string) error {
+	m.lock()
+	defer m.unlock()
 
-	item, prev := popItem(state, isStack)
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == id })
+	if index < 0 {
+		return fmt.Errorf("no item with ID %q", id)
+	}
+	items := slices.Clone(state.Items)
+	meta := maps.Clone(items[index].Meta)
+	if value == "" {
+		delete(meta, key)
+		if len(meta) == 0 {
+			meta = nil
+		}
+	} else {
+		if meta == nil {
+			meta = map[string]string{}
+		}
+		meta[key] = value
+	}
+	items[index].Meta = meta
+	prev := state.Items
+	state.Items = items
 	if err := m.save(state, func() { state.Items = prev }); err != nil {
+		return err
+	}
+	if next, ok := headItem(state); ok && next.ID == id {
+		return m.sync(state)
+	}
+	return nil
+}
+
+// pasteOrder arranges items, given in the order they should be pasted, for
+// storage: reversed for a stack, which pastes from the end, and otherwise
+// unchanged.
+func pasteOrder(state *storage.State, items []storage.Item) []storage.Item {
+	if state.Mode == storage.ModeStack {
+		slices.Reverse(items)
+	}
+	return items
+}
+
+// AddAll appends texts as separate items with a single save, e.g. when
+// importing a file, so that they paste in the given order in queue and
+// stack mode alike. With start, an inactive queue is activated too,
+// keeping its items, and started reports whether it was.
+func (m *Manager) AddAll(texts []string, start bool) (started bool, err error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return false, err
+	}
+	return m.fill(state, append(slices.Clone(state.Items), pasteOrder(state, newItems(texts))...), start)
+}
+
+// ReplaceAll discards the current items and queues texts instead, with a
+// single save, in the order AddAll queues them. start is as for AddAll.
+func (m *Manager) ReplaceAll(texts []string, start bool) (started bool, err error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return false, err
+	}
+	return m.fill(state, pasteOrder(state, newItems(texts)), start)
+}
+
+// fill replaces the items, activating the queue if start is set, and
+// rolls back on failure. It reports whether the queue was activated.
+// Must be called with m.mu held.
+func (m *Manager) fill(state *storage.State, items []storage.Item, start bool) (bool, error) {
+	before, hadHead := head(state)
+	prevItems, prevActive, prevCursor := state.Items, state.Active, state.Cursor
+	state.Items = items
+	state.Active = prevActive || start
+	followCursor(state, prevItems)
+	if err := m.save(state, func() { state.Items, state.Active, state.Cursor = prevItems, prevActive, prevCursor }); err != nil {
+		return false, err
+	}
+	if !state.Active {
+		return false, nil // the clipboard is the user's while inactive
+	}
+	return !prevActive, m.resync(state, before, hadHead)
+}
+
+func newItems(texts []string) []storage.Item {
+	items := make([]storage.Item, len(texts))
+	for i, text := range texts {
+		items[i] = storage.NewItem(text)
+	}
+	return items
+}
+
+// Pop removes the next item in the queue's mode, like a paste does (see
+// advance), without touching the clipboard.
+func (m *Manager) Pop() (string, error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return "", err
+	}
+	if len(state.Items) == 0 {
+		return "", errors.New("queue is empty")
+	}
+
+	item, rollback := advance(state)
+	if err := m.save(state, rollback); err != nil {
 		return "", err
 	}
 	return item, nil
 }
 
 // PopAndSync removes the next item, prepares the one after it on the clipboard,
-// and reads isStack from the persisted state (no TOCTOU race).
+// and reads the mode from the persisted state (no TOCTOU race). See advance
+// for items that are kept.
 func (m *Manager) PopAndSync() (string, error) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.lock()
+	defer m.unlock()
 
 	state, err := m.load()
 	if err != nil {
@@ -130,8 +541,8 @@ func (m *Manager) PopAndSync() (string, error) {
 		return "", errors.New("queue is empty")
 	}
 
-	item, prev := popItem(state, state.IsStack)
-	if err := m.save(state, func() { state.Items = prev }); err != nil {
+	item, rollback := advance(state)
+	if err := m.save(state, rollback); err != nil {
 		return "", err
 	}
 	if err := m.sync(state); err != nil {
@@ -140,58 +551,385 @@ func (m *Manager) PopAndSync() (string, error) {
 	return item, nil
 }
 
-// popItem removes the appropriate element from state.Items according to mode,
-// returns the popped value and the previous Items slice for rollback.
+// advance moves past the next item after it was pasted: an item with
+// repeats left stays next, a cycling queue moves its cursor on, and
+// otherwise the item is removed to the history, with a generator queue
+// generating its next value. It returns the pasted text and a rollback for
+// the change.
 // Must be called with m.mu held.
-func popItem(state *storage.State, isStack bool) (item string, prev []string) {
-	prev = state.Items
-	if isStack {
-		item = state.Items[len(state.Items)-1]
-		state.Items = state.Items[:len(state.Items)-1]
-	} else {
-		item = state.Items[0]
-		// Copy to a new backing array to release the memory of the old first element.
-		state.Items = append([]string(nil), state.Items[1:]...)
+func advance(state *storage.State) (string, func()) {
+	prev := *state
+	rollback := func() {
+		state.Items, state.Cursor, state.Repeated = prev.Items, prev.Cursor, prev.Repeated
+		state.Pasted, state.GeneratorPos, state.History = prev.Pasted, prev.GeneratorPos, prev.History
+	}
+
+	index := NextIndex(state)
+	item := state.Items[index]
+	state.Pasted++
+	if state.Repeated++; state.Repeated < item.Repeat {
+		return item.Text, rollback
+	}
+	state.Repeated = 0
+	switch {
+	case state.Mode == storage.ModeShuffle:
+		state.Cursor++ // counts draws, see NextIndex
+	case state.Cycle:
+		state.Cursor = (state.Cursor + 1) % len(state.Items)
 	}
+	if state.Cycle {
+		return item.Text, rollback
+	}
+	popItem(state, index)
+	state.History = remember(state.History, item)
+	generate(state)
+	return item.Text, rollback
+}
+
+// popItem removes the item at index, returning its text and the previous
+// Items slice for rollback.
+// Must be called with m.mu held.
+func popItem(state *storage.State, index int) (item string, prev []storage.Item) {
+	prev = state.Items
+	item = state.Items[index].Text
+	// Copy to a new backing array to release the memory of the removed element.
+	state.Items = slices.Delete(slices.Clone(state.Items), index, index+1)
 	return item, prev
 }
 
-// SetActive activates or deactivates collection, clearing the queue either way.
+// SetActive activates or deactivates collection, clearing the queue either
+// way except for pinned items. Activating a generator queue restarts it and
+// puts its first value on the clipboard, or its pinned items if they are
+// pasted first.
 func (m *Manager) SetActive(active bool) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.lock()
+	defer m.unlock()
 
 	state, err := m.load()
 	if err != nil {
 		return err
 	}
-	prev := storage.State{Active: state.Active, Items: state.Items, IsStack: state.IsStack}
+	// Both starting and stopping empty the queue, so keep what was in it.
+	reason := "deactivate"
+	if active {
+		reason = "activate"
+	}
+	m.snapshot(state, reason)
+	prev := *state
 	state.Active = active
-	state.Items = []string{}
-	return m.save(state, func() {
+	state.Items = append([]storage.Item{}, state.Pins...)
+	state.Pasted, state.GeneratorPos, state.Cursor, state.Repeated = 0, 0, 0, 0
+	if active {
+		state.Seed = rand.Uint64()
+		generate(state)
+	}
+	if err := m.save(state, func() {
 		state.Active = prev.Active
 		state.Items = prev.Items
-	})
+		state.Pasted, state.GeneratorPos, state.Cursor, state.Repeated = prev.Pasted, prev.GeneratorPos, prev.Cursor, prev.Repeated
+		state.Seed = prev.Seed
+	}); err != nil {
+		return err
+	}
+	return m.sync(state)
 }
 
-// SetStackMode switches between LIFO (stack) and FIFO (queue).
-func (m *Manager) SetStackMode(isStack bool) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// SetGenerator turns the queue into a generator queue producing its items
+// from pattern (see package generator) and activates it, replacing any
+// queued items other than pinned ones. An empty pattern turns the
+// generator off and empties the queue, again except for pinned items.
+func (m *Manager) SetGenerator(pattern string) error {
+	if pattern != "" {
+		if _, err := generator.Parse(pattern); err != nil {
+			return err
+		}
+	}
+
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	prev := storage.State{Active: state.Active, Items: state.Items, Generator: state.Generator, GeneratorPos: state.GeneratorPos}
+	state.Generator = pattern
+	state.GeneratorPos = 0
+	state.Items = slices.DeleteFunc(slices.Clone(state.Items), func(it storage.Item) bool { return !it.Pinned })
+	if pattern != "" {
+		state.Active = true
+		generate(state)
+	}
+	if err := m.save(state, func() {
+		state.Active = prev.Active
+		state.Items = prev.Items
+		state.Generator = prev.Generator
+		state.GeneratorPos = prev.GeneratorPos
+	}); err != nil {
+		return err
+	}
+	return m.sync(state)
+}
+
+// generate refills a generator queue that has run dry with its next value,
+// unless the pattern is exhausted. Pinned items do not count, as they would
+// otherwise keep it from ever generating.
+// Must be called with m.mu held.
+func generate(state *storage.State) {
+	if state.Generator == "" || slices.ContainsFunc(state.Items, func(it storage.Item) bool { return !it.Pinned }) {
+		return
+	}
+	g, err := generator.Parse(state.Generator)
+	if err != nil {
+		return // validated by SetGenerator
+	}
+	if text, ok := g.Generate(state.GeneratorPos, time.Now()); ok {
+		state.Items = append(slices.Clone(state.Items), storage.NewItem(text))
+		state.GeneratorPos++
+	}
+}
+
+// SetMode sets the order items are pasted in. Switching to shuffle mode
+// draws a new random order.
+func (m *Manager) SetMode(mode storage.Mode) error {
+	if _, err := storage.ParseMode(string(mode)); err != nil {
+		return err
+	}
+
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	return m.setMode(state, mode)
+}
+
+// NextMode switches to the mode after the current one among the enabled
+// modes (see SetEnabledModes) and returns it.
+func (m *Manager) NextMode() (storage.Mode, error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return "", err
+	}
+	enabled := EnabledModes(state)
+	next := enabled[0]
+	if i := slices.Index(enabled, state.Mode); i >= 0 {
+		next = enabled[(i+1)%len(enabled)]
+	}
+	if err := m.setMode(state, next); err != nil {
+		return "", err
+	}
+	return next, nil
+}
+
+// setMode switches mode, restarting cycling and repeats since positions in
+// paste order change, and re-syncs the clipboard.
+// Must be called with m.mu held.
+func (m *Manager) setMode(state *storage.State, mode storage.Mode) error {
+	before, hadHead := head(state)
+	prev := *state
+	state.Mode, state.Cursor, state.Repeated = mode, 0, 0
+	if mode == storage.ModeShuffle {
+		state.Seed = rand.Uint64()
+	}
+	if err := m.save(state, func() {
+		state.Mode, state.Cursor, state.Repeated, state.Seed = prev.Mode, prev.Cursor, prev.Repeated, prev.Seed
+	}); err != nil {
+		return err
+	}
+	return m.resync(state, before, hadHead)
+}
+
+// SetEnabledModes sets the modes NextMode cycles through, in order.
+func (m *Manager) SetEnabledModes(modes []storage.Mode) error {
+	if len(modes) == 0 {
+		return errors.New("at least one mode must be enabled")
+	}
+	for _, mode := range modes {
+		if _, err := storage.ParseMode(string(mode)); err != nil {
+			return err
+		}
+	}
+
+	m.lock()
+	defer m.unlock()
 
 	state, err := m.load()
 	if err != nil {
 		return err
 	}
-	prev := state.IsStack
-	state.IsStack = isStack
-	return m.save(state, func() { state.IsStack = prev })
+	prev := state.Modes
+	state.Modes = slices.Compact(slices.Clone(modes))
+	return m.save(state, func() { state.Modes = prev })
+}
+
+// EnabledModes returns the modes Cmd+M cycles through.
+func EnabledModes(state *storage.State) []storage.Mode {
+	if len(state.Modes) == 0 {
+		return []storage.Mode{storage.ModeQueue, storage.ModeStack}
+	}
+	return state.Modes
+}
+
+// SetPriority sets the priority of the item with the given ID, used in
+// priority mode.
+func (m *Manager) SetPriority(id string, priority int) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == id })
+	if index < 0 {
+		return fmt.Errorf("no item with ID %q", id)
+	}
+	items := slices.Clone(state.Items)
+	items[index].Priority = priority
+	return m.replaceItems(state, items)
+}
+
+// Pin pins the item with the given ID so it is pasted before or after all
+// others (see SetPinPosition) and comes back whenever the queue is cleared.
+func (m *Manager) Pin(id string) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == id })
+	if index < 0 {
+		return fmt.Errorf("no item with ID %q", id)
+	}
+	if state.Items[index].Pinned {
+		return nil
+	}
+	items := slices.Clone(state.Items)
+	items[index].Pinned = true
+	prevPins := state.Pins
+	state.Pins = append(slices.Clone(state.Pins), items[index])
+	if err := m.replaceItems(state, items); err != nil {
+		state.Pins = prevPins
+		return err
+	}
+	return nil
+}
+
+// Unpin unpins the pinned item with the given ID, or unique ID prefix,
+// whether or not it is currently queued, and returns its text. A queued
+// copy stays in the queue as an ordinary item.
+func (m *Manager) Unpin(ref string) (string, error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return "", err
+	}
+	i, err := findID(state.Pins, ref)
+	if err != nil {
+		return "", err
+	}
+	if i < 0 {
+		return "", fmt.Errorf("no pinned item with ID %q", ref)
+	}
+	pin := state.Pins[i]
+	items := slices.Clone(state.Items)
+	for j := range items {
+		if items[j].ID == pin.ID {
+			items[j].Pinned = false
+		}
+	}
+	prevPins := state.Pins
+	state.Pins = slices.Delete(slices.Clone(state.Pins), i, i+1)
+	if err := m.replaceItems(state, items); err != nil {
+		state.Pins = prevPins
+		return "", err
+	}
+	return pin.Text, nil
+}
+
+// SetPinPosition sets whether pinned items are pasted before or after all
+// others.
+func (m *Manager) SetPinPosition(pos storage.PinPosition) error {
+	if pos != storage.PinFirst && pos != storage.PinLast {
+		return fmt.Errorf("unknown pin position %q (want first or last)", pos)
+	}
+
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	before, hadHead := head(state)
+	prev := state.PinPosition
+	state.PinPosition = pos
+	if err := m.save(state, func() { state.PinPosition = prev }); err != nil {
+		return err
+	}
+	return m.resync(state, before, hadHead)
+}
+
+// SetCycleMode turns cycle mode on or off. A cycling queue keeps pasted
+// items and wraps around at the end instead of draining; turning it on
+// starts again from the first item.
+func (m *Manager) SetCycleMode(cycle bool) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	before, hadHead := head(state)
+	prev := *state
+	state.Cycle, state.Cursor, state.Repeated = cycle, 0, 0
+	if err := m.save(state, func() {
+		state.Cycle, state.Cursor, state.Repeated = prev.Cycle, prev.Cursor, prev.Repeated
+	}); err != nil {
+		return err
+	}
+	return m.resync(state, before, hadHead)
+}
+
+// SetRepeat sets how many times the item with the given ID is pasted
+// before the queue moves on; 1 or less means once.
+func (m *Manager) SetRepeat(id string, times int) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == id })
+	if index < 0 {
+		return fmt.Errorf("no item with ID %q", id)
+	}
+	items := slices.Clone(state.Items)
+	items[index].Repeat = max(times, 0)
+	if items[index].Repeat == 1 {
+		items[index].Repeat = 0
+	}
+	prev := state.Items
+	state.Items = items
+	return m.save(state, func() { state.Items = prev })
 }
 
 // SyncClipboard writes the current "next" item to the system clipboard.
 func (m *Manager) SyncClipboard() error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+	m.lock()
+	defer m.unlock()
 
 	state, err := m.load()
 	if err != nil {
@@ -200,33 +938,411 @@ func (m *Manager) SyncClipboard() error {
 	return m.sync(state)
 }
 
-// sync writes the next item to the clipboard.
+// sync writes the next item to the clipboard, after the paste
+// transformations and template.
 // Must be called with m.mu held.
 func (m *Manager) sync(state *storage.State) error {
-	if len(state.Items) == 0 {
+	item, ok := headItem(state)
+	if !ok {
 		return nil
 	}
-	next := state.Items[0]
-	if state.IsStack {
-		next = state.Items[len(state.Items)-1]
+	next := transform.Chain(state.PasteTransforms).Apply(item.Text)
+	if state.Template != "" {
+		var err error
+		next, err = renderPaste(state.Template, PasteData{
+			Text:    next,
+			Index:   state.Pasted,
+			Counter: state.Pasted + 1,
+			Time:    time.Now(),
+			Created: item.Created,
+			Meta:    item.Meta,
+		})
+		if err != nil {
+			return err
+		}
 	}
-	return m.clipboard.Write(next)
+	if err := m.clipboard.Write(next); err != nil {
+		return err
+	}
+	m.written, m.syncedID, m.syncedText = next, item.ID, item.Text
+	return nil
 }
 
-// GetStatus returns the current state.
-func (m *Manager) GetStatus() (*storage.State, error) {
+// Wrote reports whether text is the value cbq last put on the clipboard.
+// With paste transformations that value need not match any item, so the
+// clipboard poller uses this to tell its own writes from user copies.
+func (m *Manager) Wrote(text string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	return m.written != "" && m.written == text
+}
+
+// head returns the text that will be pasted next, if any.
+func head(state *storage.State) (string, bool) {
+	item, ok := headItem(state)
+	return item.Text, ok
+}
+
+// headItem returns the item that will be pasted next, if any.
+func headItem(state *storage.State) (storage.Item, bool) {
+	i := NextIndex(state)
+	if i < 0 {
+		return storage.Item{}, false
+	}
+	return state.Items[i], true
+}
+
+// GetStatus returns the current state.
+func (m *Manager) GetStatus() (*storage.State, error) {
+	m.lock()
+	defer m.unlock()
+	return m.load()
+}
+
+// Refresh picks up changes made to the stored state by other processes or
+// by hand and, if the queue is active and the item to paste next is not
+// the one this Manager last put on the clipboard, syncs the clipboard. It
+// returns the current state, like GetStatus, and reports whether it was
+// re-read.
+func (m *Manager) Refresh() (state *storage.State, reread bool, err error) {
+	m.lock()
+	defer m.unlock()
+
+	reread = m.state == nil
+	if state, err = m.load(); err != nil {
+		return nil, false, err
+	}
+	if !state.Active {
+		return state, reread, nil
+	}
+	if item, ok := headItem(state); ok && (item.ID != m.syncedID || item.Text != m.syncedText) {
+		return state, reread, m.sync(state)
+	}
+	return state, reread, nil
+}
+
+// Reload discards the cached state and reads it from storage again,
+// picking up changes written by another process such as the monitor.
+func (m *Manager) Reload() (*storage.State, error) {
+	m.lock()
+	defer m.unlock()
+	m.state = nil
 	return m.load()
 }
 
-// Clear empties the queue.
+// Clear empties the queue, keeping pinned items, and starts a cycling queue
+// and any repeats over.
 func (m *Manager) Clear() error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	if err := m.storage.Clear(); err != nil {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	prev := *state
+	state.Items = append([]storage.Item{}, state.Pins...)
+	state.Cursor, state.Repeated = 0, 0
+	return m.save(state, func() {
+		state.Items, state.Cursor, state.Repeated = prev.Items, prev.Cursor, prev.Repeated
+	})
+}
+
+// InsertAt inserts item so that it ends up at index (0 <= index <= len).
+func (m *Manager) InsertAt(index int, item string) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
 		return err
 	}
-	m.state = nil // invalidate cache
+	if index < 0 || index > len(state.Items) {
+		return indexError(index, len(state.Items))
+	}
+	return m.replaceItems(state, slices.Insert(slices.Clone(state.Items), index, storage.NewItem(item)))
+}
+
+// DeleteAt removes and returns the item at index.
+func (m *Manager) DeleteAt(index int) (string, error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return "", err
+	}
+	if err := checkIndex(index, len(state.Items)); err != nil {
+		return "", err
+	}
+	item := state.Items[index]
+	if err := m.replaceItems(state, slices.Delete(slices.Clone(state.Items), index, index+1)); err != nil {
+		return "", err
+	}
+	return item.Text, nil
+}
+
+// ReplaceAt overwrites the item at index.
+func (m *Manager) ReplaceAt(index int, item string) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	if err := checkIndex(index, len(state.Items)); err != nil {
+		return err
+	}
+	items := slices.Clone(state.Items)
+	items[index].Text = item
+	return m.replaceItems(state, items)
+}
+
+// Move relocates the item at from so that it ends up at index to.
+func (m *Manager) Move(from, to int) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	if err := checkIndex(from, len(state.Items)); err != nil {
+		return err
+	}
+	if err := checkIndex(to, len(state.Items)); err != nil {
+		return err
+	}
+	item := state.Items[from]
+	items := slices.Delete(slices.Clone(state.Items), from, from+1)
+	return m.replaceItems(state, slices.Insert(items, to, item))
+}
+
+// Swap exchanges the items at i and j.
+func (m *Manager) Swap(i, j int) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	if err := checkIndex(i, len(state.Items)); err != nil {
+		return err
+	}
+	if err := checkIndex(j, len(state.Items)); err != nil {
+		return err
+	}
+	items := slices.Clone(state.Items)
+	items[i], items[j] = items[j], items[i]
+	return m.replaceItems(state, items)
+}
+
+// CreatedBetween returns the queued items created in [from, to), oldest
+// first, through the storage's index by creation time if it has one.
+func (m *Manager) CreatedBetween(from, to time.Time) ([]storage.Item, error) {
+	m.lock()
+	defer m.unlock()
+	if m.lockErr != nil {
+		return nil, m.lockErr
+	}
+	return storage.CreatedBetween(m.storage, from, to)
+}
+
+// Lookup resolves ref, an index or an item ID (or unique ID prefix), to
+// the item's index and a copy of the item.
+func (m *Manager) Lookup(ref string) (int, storage.Item, error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return 0, storage.Item{}, err
+	}
+	index, err := resolve(state.Items, ref)
+	if err != nil {
+		return 0, storage.Item{}, err
+	}
+	return index, state.Items[index], nil
+}
+
+// EditItem sets the text of the item with the given ID, provided it still
+// reads expected; otherwise it returns ErrConflict. State is re-read from
+// storage first, since an edit may take long enough for another process to
+// change the queue.
+func (m *Manager) EditItem(id, expected, text string) error {
+	m.lock()
+	defer m.unlock()
+
+	m.state = nil
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	index := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == id })
+	if index < 0 {
+		return fmt.Errorf("%w: item %s was removed", ErrConflict, id)
+	}
+	if state.Items[index].Text != expected {
+		return fmt.Errorf("%w: item %s was modified", ErrConflict, id)
+	}
+	items := slices.Clone(state.Items)
+	items[index].Text = text
+	return m.replaceItems(state, items)
+}
+
+// DeleteItem removes the item with the given ID, provided it is still
+// there, and returns its text. Unlike DeleteAt it cannot remove another
+// item when the queue changed since the caller read it.
+func (m *Manager) DeleteItem(id string) (string, error) {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return "", err
+	}
+	index := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == id })
+	if index < 0 {
+		return "", fmt.Errorf("%w: item %s was removed", ErrConflict, id)
+	}
+	item := state.Items[index]
+	if err := m.replaceItems(state, slices.Delete(slices.Clone(state.Items), index, index+1)); err != nil {
+		return "", err
+	}
+	return item.Text, nil
+}
+
+// MoveItem moves the item with ID id to where the item with ID to is now,
+// provided both are still there.
+func (m *Manager) MoveItem(id, to string) error {
+	m.lock()
+	defer m.unlock()
+
+	state, err := m.load()
+	if err != nil {
+		return err
+	}
+	from := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == id })
+	dest := slices.IndexFunc(state.Items, func(it storage.Item) bool { return it.ID == to })
+	switch {
+	case from < 0:
+		return fmt.Errorf("%w: item %s was removed", ErrConflict, id)
+	case dest < 0:
+		return fmt.Errorf("%w: item %s was removed", ErrConflict, to)
+	}
+	item := state.Items[from]
+	items := slices.Delete(slices.Clone(state.Items), from, from+1)
+	return m.replaceItems(state, slices.Insert(items, dest, item))
+}
+
+// minNumericPrefix is the length from which an out-of-range number is
+// tried as an ID prefix, since IDs may consist of digits only.
+const minNumericPrefix = 4
+
+// resolve finds the index an item reference points to. A number is an
+// index if it is in range or too short to be taken for an ID prefix.
+func resolve(items []storage.Item, ref string) (int, error) {
+	index, atoiErr := strconv.Atoi(ref)
+	if atoiErr == nil && (index >= 0 && index < len(items) || len(ref) < minNumericPrefix) {
+		if err := checkIndex(index, len(items)); err != nil {
+			return 0, err
+		}
+		return index, nil
+	}
+	found, err := findID(items, ref)
+	if err != nil {
+		return 0, err
+	}
+	if found < 0 && atoiErr == nil {
+		return 0, indexError(index, len(items))
+	}
+	if found < 0 {
+		return 0, fmt.Errorf("no item with index or ID %q", ref)
+	}
+	return found, nil
+}
+
+// findID returns the index of the item with ID ref, or of the only item
+// whose ID starts with ref, or -1 if there is none.
+func findID(items []storage.Item, ref string) (int, error) {
+	if ref == "" {
+		return -1, nil
+	}
+	found := -1
+	for i, item := range items {
+		if item.ID == ref {
+			return i, nil
+		}
+		if strings.HasPrefix(item.ID, ref) {
+			if found >= 0 {
+				return 0, fmt.Errorf("item ID prefix %q is ambiguous", ref)
+			}
+			found = i
+		}
+	}
+	return found, nil
+}
+
+// replaceItems persists a new item list, rolling back on failure, and
+// re-syncs the clipboard if the next item to paste changed. The cursor of a
+// cycling queue follows the change (see followCursor).
+// Must be called with m.mu held.
+func (m *Manager) replaceItems(state *storage.State, items []storage.Item) error {
+	beforeItem, hadHead := headItem(state)
+	before := beforeItem.Text
+	prev, prevCursor, prevRepeated, prevPins := state.Items, state.Cursor, state.Repeated, state.Pins
+	state.Items = items
+	followCursor(state, prev)
+	if after, _ := headItem(state); after.ID != beforeItem.ID {
+		state.Repeated = 0 // repeats counted for an item that is no longer next
+	}
+	state.Pins = updatePins(state.Pins, items)
+	if err := m.save(state, func() {
+		state.Items, state.Cursor, state.Repeated, state.Pins = prev, prevCursor, prevRepeated, prevPins
+	}); err != nil {
+		return err
+	}
+	return m.resync(state, before, hadHead)
+}
+
+// updatePins returns pins with every pinned item that is also in items
+// replaced by its current version, so edits to a pinned item last.
+func updatePins(pins, items []storage.Item) []storage.Item {
+	var out []storage.Item
+	for i, pin := range pins {
+		j := slices.IndexFunc(items, func(it storage.Item) bool { return it.ID == pin.ID })
+		if j < 0 || !items[j].Pinned {
+			continue
+		}
+		if out == nil {
+			out = slices.Clone(pins)
+		}
+		out[i] = items[j]
+	}
+	if out == nil {
+		return pins
+	}
+	return out
+}
+
+// resync writes the next item to the clipboard if it differs from before.
+// Must be called with m.mu held.
+func (m *Manager) resync(state *storage.State, before string, hadHead bool) error {
+	if after, ok := head(state); ok && (!hadHead || after != before) {
+		return m.sync(state)
+	}
 	return nil
 }
+
+func checkIndex(index, n int) error {
+	if index < 0 || index >= n {
+		return indexError(index, n)
+	}
+	return nil
+}
+
+func indexError(index, n int) error {
+	return fmt.Errorf("index %d out of range (queue has %d items)", index, n)
+}
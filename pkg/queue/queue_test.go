@@ -1,38 +1,77 @@
 package queue
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+	"github.com/matouschdavid/Clipboard-queue/pkg/transform"
 )
 
-// MockStorage implements storage.Storage for testing.
-type MockStorage struct {
-	state *storage.State
-	err   error
+// testStorage is a storage.MemoryStorage that can fail on demand, counts
+// saves, and reports the changes tests make through update, as if another
+// process made them.
+type testStorage struct {
+	*storage.MemoryStorage
+	err     error
+	saves   int
+	changed bool
 }
 
-func (m *MockStorage) Load() (*storage.State, error) {
-	if m.err != nil {
-		return nil, m.err
+func newTestStorage(state *storage.State) *testStorage {
+	s := &testStorage{MemoryStorage: storage.NewMemoryStorage()}
+	s.MemoryStorage.Save(state)
+	return s
+}
+
+func (s *testStorage) Load() (*storage.State, error) {
+	if s.err != nil {
+		return nil, s.err
 	}
-	return m.state, nil
+	return s.MemoryStorage.Load()
 }
 
-func (m *MockStorage) Save(state *storage.State) error {
-	if m.err != nil {
-		return m.err
+func (s *testStorage) Save(state *storage.State) error {
+	if s.err != nil {
+		return s.err
 	}
-	m.state = state
-	return nil
+	s.saves++
+	return s.MemoryStorage.Save(state)
 }
 
-func (m *MockStorage) Clear() error {
-	if m.err != nil {
-		return m.err
+func (s *testStorage) Clear() error {
+	if s.err != nil {
+		return s.err
 	}
-	m.state.Items = []string{}
-	return nil
+	return s.MemoryStorage.Clear()
+}
+
+func (s *testStorage) Changed() (bool, error) {
+	changed := s.changed
+	s.changed = false
+	return changed, nil
+}
+
+// state returns a copy of the stored state.
+func (s *testStorage) state() *storage.State {
+	state, _ := s.MemoryStorage.Load()
+	return state
+}
+
+// update changes the stored state behind the Manager's back.
+func (s *testStorage) update(fn func(state *storage.State)) {
+	state := s.state()
+	fn(state)
+	s.MemoryStorage.Save(state)
+	s.changed = true
 }
 
 // MockClipboard implements Clipboard for testing.
@@ -53,23 +92,32 @@ func (m *MockClipboard) Write(text string) error {
 	return nil
 }
 
+// items builds queue items from their texts.
+func items(texts ...string) []storage.Item {
+	out := make([]storage.Item, len(texts))
+	for i, text := range texts {
+		out[i] = storage.NewItem(text)
+	}
+	return out
+}
+
 func TestManager_Add(t *testing.T) {
-	s := &MockStorage{state: &storage.State{Active: true, Items: []string{}}}
+	s := newTestStorage(&storage.State{Active: true, Items: items()})
 	c := &MockClipboard{}
 	mgr := NewManager(s, c)
 
 	if err := mgr.Add("item1"); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(s.state.Items) != 1 || s.state.Items[0] != "item1" {
-		t.Errorf("item1 not added: %v", s.state.Items)
+	if len(s.state().Items) != 1 || s.state().Items[0].Text != "item1" {
+		t.Errorf("item1 not added: %v", s.state().Items)
 	}
 
 	if err := mgr.Add("item2"); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(s.state.Items) != 2 || s.state.Items[0] != "item1" {
-		t.Errorf("state incorrect after item2: %v", s.state.Items)
+	if len(s.state().Items) != 2 || s.state().Items[0].Text != "item1" {
+		t.Errorf("state incorrect after item2: %v", s.state().Items)
 	}
 
 	// SyncClipboard should put the first item (FIFO next) onto the clipboard.
@@ -81,34 +129,34 @@ func TestManager_Add(t *testing.T) {
 	}
 
 	// Inactive: add should be a no-op.
-	s.state.Active = false
+	s.update(func(st *storage.State) { st.Active = false })
 	if err := mgr.Add("item3"); err != nil {
 		t.Fatalf("unexpected error when inactive: %v", err)
 	}
-	if len(s.state.Items) != 2 {
+	if len(s.state().Items) != 2 {
 		t.Errorf("item added while inactive")
 	}
 
 	// Duplicate of last item should be rejected.
-	s.state.Active = true
+	s.update(func(st *storage.State) { st.Active = true })
 	if err := mgr.Add("item2"); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if len(s.state.Items) != 2 {
+	if len(s.state().Items) != 2 {
 		t.Errorf("duplicate was added")
 	}
 }
 
 func TestManager_Pop(t *testing.T) {
-	s := &MockStorage{state: &storage.State{
+	s := newTestStorage(&storage.State{
 		Active: true,
-		Items:  []string{"item1", "item2", "item3"},
-	}}
+		Items:  items("item1", "item2", "item3"),
+	})
 	c := &MockClipboard{}
 	mgr := NewManager(s, c)
 
 	// FIFO pop.
-	item, err := mgr.Pop(false)
+	item, err := mgr.Pop()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -119,17 +167,17 @@ func TestManager_Pop(t *testing.T) {
 	if c.content != "item2" {
 		t.Errorf("expected clipboard=item2 after FIFO pop, got %q", c.content)
 	}
-	if len(s.state.Items) != 2 || s.state.Items[0] != "item2" {
-		t.Errorf("wrong state after FIFO pop: %v", s.state.Items)
+	if len(s.state().Items) != 2 || s.state().Items[0].Text != "item2" {
+		t.Errorf("wrong state after FIFO pop: %v", s.state().Items)
 	}
 
 	// LIFO pop.
-	s.state.Items = []string{"item1", "item2", "item3"}
-	s.state.IsStack = true
-	// invalidate cache so load() picks up the reset state
-	mgr.state = nil
+	s.update(func(st *storage.State) {
+		st.Items = items("item1", "item2", "item3")
+		st.Mode = storage.ModeStack
+	})
 
-	item, err = mgr.Pop(true)
+	item, err = mgr.Pop()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -141,7 +189,7 @@ func TestManager_Pop(t *testing.T) {
 		t.Errorf("expected clipboard=item2 after LIFO pop, got %q", c.content)
 	}
 
-	item, err = mgr.Pop(true)
+	item, err = mgr.Pop()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -153,65 +201,77 @@ func TestManager_Pop(t *testing.T) {
 		t.Errorf("expected clipboard=item1, got %q", c.content)
 	}
 
-	item, err = mgr.Pop(true)
+	item, err = mgr.Pop()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 	if item != "item1" {
 		t.Errorf("expected item1, got %q", item)
 	}
-	if len(s.state.Items) != 0 {
-		t.Errorf("expected empty queue, got %v", s.state.Items)
+	if len(s.state().Items) != 0 {
+		t.Errorf("expected empty queue, got %v", s.state().Items)
 	}
 
 	// Empty queue should error.
-	if _, err := mgr.Pop(true); err == nil {
+	if _, err := mgr.Pop(); err == nil {
 		t.Error("expected error on empty pop")
 	}
+
+	// Any other mode, and repeat counts, apply as well.
+	s.update(func(st *storage.State) {
+		st.Items = items("low", "high")
+		st.Items[1].Priority, st.Items[1].Repeat = 1, 2
+		st.Mode = storage.ModePriority
+	})
+	for _, want := range []string{"high", "high", "low"} {
+		if item, err := mgr.Pop(); err != nil || item != want {
+			t.Errorf("expected %s, got %q: %v", want, item, err)
+		}
+	}
 }
 
 func TestManager_SetActive(t *testing.T) {
-	s := &MockStorage{state: &storage.State{
+	s := newTestStorage(&storage.State{
 		Active: false,
-		Items:  []string{"something"},
-	}}
+		Items:  items("something"),
+	})
 	mgr := NewManager(s, &MockClipboard{})
 
 	if err := mgr.SetActive(true); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !s.state.Active {
+	if !s.state().Active {
 		t.Error("expected active")
 	}
-	if len(s.state.Items) != 0 {
+	if len(s.state().Items) != 0 {
 		t.Error("expected items cleared on activate")
 	}
 
-	s.state.Items = append(s.state.Items, "item")
+	s.update(func(st *storage.State) { st.Items = append(st.Items, storage.NewItem("item")) })
 	if err := mgr.SetActive(false); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if s.state.Active {
+	if s.state().Active {
 		t.Error("expected inactive")
 	}
-	if len(s.state.Items) != 0 {
+	if len(s.state().Items) != 0 {
 		t.Error("expected items cleared on deactivate")
 	}
 }
 
-func TestManager_SetStackMode(t *testing.T) {
-	s := &MockStorage{state: &storage.State{
-		Active:  true,
-		Items:   []string{"item1", "item2"},
-		IsStack: false,
-	}}
+func TestManager_SetMode(t *testing.T) {
+	s := newTestStorage(&storage.State{
+		Active: true,
+		Items:  items("item1", "item2"),
+		Mode:   storage.ModeQueue,
+	})
 	c := &MockClipboard{}
 	mgr := NewManager(s, c)
 
-	if err := mgr.SetStackMode(true); err != nil {
+	if err := mgr.SetMode(storage.ModeStack); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if !s.state.IsStack {
+	if s.state().Mode != storage.ModeStack {
 		t.Error("expected stack mode")
 	}
 	mgr.SyncClipboard()
@@ -219,10 +279,10 @@ func TestManager_SetStackMode(t *testing.T) {
 		t.Errorf("expected clipboard=item2 in stack mode, got %q", c.content)
 	}
 
-	if err := mgr.SetStackMode(false); err != nil {
+	if err := mgr.SetMode(storage.ModeQueue); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
-	if s.state.IsStack {
+	if s.state().Mode != storage.ModeQueue {
 		t.Error("expected queue mode")
 	}
 	mgr.SyncClipboard()
@@ -232,7 +292,7 @@ func TestManager_SetStackMode(t *testing.T) {
 }
 
 func TestManager_AddAndSync(t *testing.T) {
-	s := &MockStorage{state: &storage.State{Active: true, Items: []string{}}}
+	s := newTestStorage(&storage.State{Active: true, Items: items()})
 	c := &MockClipboard{}
 	mgr := NewManager(s, c)
 
@@ -250,12 +310,12 @@ func TestManager_AddAndSync(t *testing.T) {
 	if c.content != "item1" {
 		t.Errorf("expected clipboard=item1 (FIFO), got %q", c.content)
 	}
-	if len(s.state.Items) != 2 {
-		t.Errorf("expected 2 items, got %d", len(s.state.Items))
+	if len(s.state().Items) != 2 {
+		t.Errorf("expected 2 items, got %d", len(s.state().Items))
 	}
 
 	// In stack mode, clipboard should advance to newest item.
-	s.state.IsStack = true
+	s.update(func(st *storage.State) { st.Mode = storage.ModeStack })
 	if err := mgr.AddAndSync("item3"); err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -265,11 +325,11 @@ func TestManager_AddAndSync(t *testing.T) {
 }
 
 func TestManager_PopAndSync(t *testing.T) {
-	s := &MockStorage{state: &storage.State{
-		Active:  true,
-		IsStack: false,
-		Items:   []string{"item1", "item2", "item3"},
-	}}
+	s := newTestStorage(&storage.State{
+		Active: true,
+		Mode:   storage.ModeQueue,
+		Items:  items("item1", "item2", "item3"),
+	})
 	c := &MockClipboard{}
 	mgr := NewManager(s, c)
 
@@ -297,9 +357,10 @@ func TestManager_PopAndSync(t *testing.T) {
 	}
 
 	// Switch to LIFO.
-	s.state.Items = []string{"item1", "item2", "item3"}
-	s.state.IsStack = true
-	mgr.state = nil // invalidate cache
+	s.update(func(st *storage.State) {
+		st.Items = items("item1", "item2", "item3")
+		st.Mode = storage.ModeStack
+	})
 
 	item, err = mgr.PopAndSync()
 	if err != nil {
@@ -317,19 +378,1018 @@ func TestManager_FIFOMemoryLeak(t *testing.T) {
 	// Verify that repeated FIFO pops don't retain the old backing array.
 	// We can't inspect the internal array directly, but we can confirm correct
 	// values are returned across many pops without panicking.
-	items := make([]string, 100)
-	for i := range items {
-		items[i] = "x"
+	texts := make([]string, 100)
+	for i := range texts {
+		texts[i] = "x"
 	}
-	s := &MockStorage{state: &storage.State{Active: true, Items: items}}
+	s := newTestStorage(&storage.State{Active: true, Items: items(texts...)})
 	mgr := NewManager(s, &MockClipboard{})
 
 	for i := 0; i < 100; i++ {
-		if _, err := mgr.Pop(false); err != nil {
+		if _, err := mgr.Pop(); err != nil {
 			t.Fatalf("pop %d failed: %v", i, err)
 		}
 	}
-	if len(s.state.Items) != 0 {
-		t.Errorf("expected empty queue, got %d items", len(s.state.Items))
+	if len(s.state().Items) != 0 {
+		t.Errorf("expected empty queue, got %d items", len(s.state().Items))
+	}
+}
+
+func TestManager_InsertAt(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "c")})
+	c := &MockClipboard{content: "a"}
+	mgr := NewManager(s, c)
+
+	if err := mgr.InsertAt(1, "b"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"a", "b", "c"}) {
+		t.Errorf("wrong items after insert: %v", s.state().Items)
+	}
+
+	// Inserting a new head re-syncs the clipboard.
+	if err := mgr.InsertAt(0, "first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "first" {
+		t.Errorf("expected clipboard=first, got %q", c.content)
+	}
+
+	// Appending at len is allowed, beyond is not.
+	if err := mgr.InsertAt(4, "last"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.InsertAt(9, "x"); err == nil {
+		t.Error("expected out of range error")
+	}
+}
+
+func TestManager_DeleteAt(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b", "c")})
+	c := &MockClipboard{content: "a"}
+	mgr := NewManager(s, c)
+
+	item, err := mgr.DeleteAt(1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if item != "b" || !slices.Equal(storage.Texts(s.state().Items), []string{"a", "c"}) {
+		t.Errorf("wrong delete result %q, items %v", item, s.state().Items)
+	}
+	if c.content != "a" {
+		t.Errorf("clipboard changed although head did not: %q", c.content)
+	}
+
+	// Stack mode: deleting the top moves the head.
+	s.update(func(st *storage.State) { st.Mode = storage.ModeStack })
+	if _, err := mgr.DeleteAt(1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "a" {
+		t.Errorf("expected clipboard=a, got %q", c.content)
+	}
+	if _, err := mgr.DeleteAt(-1); err == nil {
+		t.Error("expected out of range error")
+	}
+}
+
+func TestManager_ReplaceAt(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if err := mgr.ReplaceAt(0, "A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"A", "b"}) {
+		t.Errorf("wrong items after replace: %v", s.state().Items)
+	}
+	if c.content != "A" {
+		t.Errorf("expected clipboard=A, got %q", c.content)
+	}
+	if err := mgr.ReplaceAt(2, "x"); err == nil {
+		t.Error("expected out of range error")
+	}
+}
+
+func TestManager_MoveAndSwap(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b", "c", "d")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if err := mgr.Move(0, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"b", "c", "a", "d"}) {
+		t.Errorf("wrong items after move forward: %v", s.state().Items)
+	}
+	if c.content != "b" {
+		t.Errorf("expected clipboard=b, got %q", c.content)
+	}
+
+	if err := mgr.Move(3, 0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"d", "b", "c", "a"}) {
+		t.Errorf("wrong items after move back: %v", s.state().Items)
+	}
+
+	if err := mgr.Swap(0, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"a", "b", "c", "d"}) {
+		t.Errorf("wrong items after swap: %v", s.state().Items)
+	}
+	if c.content != "a" {
+		t.Errorf("expected clipboard=a, got %q", c.content)
+	}
+
+	if err := mgr.Move(0, 4); err == nil {
+		t.Error("expected out of range error")
+	}
+	if err := mgr.Swap(-1, 0); err == nil {
+		t.Error("expected out of range error")
+	}
+}
+
+func TestManager_DeleteAndMoveItem(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b", "c", "d")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+	a, b, d := s.state().Items[0], s.state().Items[1], s.state().Items[3]
+
+	if err := mgr.MoveItem(a.ID, d.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"b", "c", "d", "a"}) {
+		t.Errorf("wrong items after move: %v", s.state().Items)
+	}
+	if text, err := mgr.DeleteItem(d.ID); err != nil || text != "d" {
+		t.Fatalf("unexpected delete result %q: %v", text, err)
+	}
+
+	// The item was popped by the monitor in the meantime.
+	if _, err := mgr.PopAndSync(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.DeleteItem(b.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict deleting a popped item, got %v", err)
+	}
+	if err := mgr.MoveItem(a.ID, b.ID); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict moving to a popped item, got %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"c", "a"}) {
+		t.Errorf("items changed by a failed call: %v", s.state().Items)
+	}
+}
+
+func TestManager_EditRollback(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b")})
+	c := &MockClipboard{content: "a"}
+	mgr := NewManager(s, c)
+	if _, err := mgr.GetStatus(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	s.err = errors.New("disk full")
+	if err := mgr.Swap(0, 1); err == nil {
+		t.Fatal("expected save error")
+	}
+	if _, err := mgr.DeleteAt(0); err == nil {
+		t.Fatal("expected save error")
+	}
+	if !slices.Equal(storage.Texts(mgr.state.Items), []string{"a", "b"}) {
+		t.Errorf("cache not rolled back: %v", mgr.state.Items)
+	}
+	if c.content != "a" {
+		t.Errorf("clipboard synced despite failed save: %q", c.content)
+	}
+}
+
+func TestManager_LookupAndEditItem(t *testing.T) {
+	// Fixed IDs: random ones may consist of digits and be taken for indices.
+	s := newTestStorage(&storage.State{Active: true, Items: []storage.Item{
+		{ID: "aaaa0001", Text: "a"}, {ID: "bbbb0002", Text: "b"}, {ID: "cccc0003", Text: "c"},
+	}})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	idx, item, err := mgr.Lookup("1")
+	if err != nil || idx != 1 || item.Text != "b" {
+		t.Fatalf("lookup by index: %d %+v %v", idx, item, err)
+	}
+	idx, item, err = mgr.Lookup("cccc0")
+	if err != nil || idx != 2 || item.Text != "c" {
+		t.Fatalf("lookup by ID prefix: %d %+v %v", idx, item, err)
+	}
+	if _, _, err := mgr.Lookup("7"); err == nil {
+		t.Error("expected error for index out of range")
+	}
+	if _, _, err := mgr.Lookup("zzzz"); err == nil {
+		t.Error("expected error for unknown ID")
+	}
+
+	// Editing the head re-syncs the clipboard.
+	head := s.state().Items[0]
+	if err := mgr.EditItem(head.ID, "a", "A"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.state().Items[0].Text != "A" || s.state().Items[0].ID != head.ID {
+		t.Errorf("edit not applied in place: %+v", s.state().Items[0])
+	}
+	if c.content != "A" {
+		t.Errorf("expected clipboard=A, got %q", c.content)
+	}
+
+	// The item changed since it was read.
+	if err := mgr.EditItem(head.ID, "a", "again"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for modified item, got %v", err)
+	}
+
+	// The item was removed, e.g. pasted by the monitor, while editing.
+	gone := s.state().Items[1]
+	s.update(func(st *storage.State) { st.Items = slices.Delete(st.Items, 1, 2) })
+	if err := mgr.EditItem(gone.ID, "b", "B"); !errors.Is(err, ErrConflict) {
+		t.Errorf("expected ErrConflict for removed item, got %v", err)
+	}
+}
+
+func TestManager_AddAllAndReplaceAll(t *testing.T) {
+	s := newTestStorage(&storage.State{Items: items("a")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if started, err := mgr.AddAll([]string{"b", "b", "c"}, true); err != nil || !started {
+		t.Fatalf("expected the queue started, got %v: %v", started, err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"a", "b", "b", "c"}) {
+		t.Errorf("wrong items after AddAll: %v", s.state().Items)
+	}
+	if !s.state().Active {
+		t.Error("expected AddAll to activate the queue")
+	}
+
+	if _, err := mgr.ReplaceAll([]string{"x", "y"}, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"x", "y"}) {
+		t.Errorf("wrong items after ReplaceAll: %v", s.state().Items)
+	}
+	if c.content != "x" {
+		t.Errorf("expected clipboard=x, got %q", c.content)
+	}
+
+	// Rollback keeps the previous items and active flag.
+	s.update(func(st *storage.State) { *st = storage.State{Items: items("a")} })
+	mgr.GetStatus()
+	s.err = errors.New("disk full")
+	if _, err := mgr.ReplaceAll([]string{"z"}, true); err == nil {
+		t.Fatal("expected save error")
+	}
+	if mgr.state.Active || !slices.Equal(storage.Texts(mgr.state.Items), []string{"a"}) {
+		t.Errorf("cache not rolled back: %+v", mgr.state)
+	}
+}
+
+func TestManager_AddAllWithoutStart(t *testing.T) {
+	s := newTestStorage(&storage.State{Items: items("a")})
+	c := &MockClipboard{content: "user copy"}
+	mgr := NewManager(s, c)
+
+	if started, err := mgr.AddAll([]string{"b"}, false); err != nil || started {
+		t.Fatalf("expected the queue left alone, got %v: %v", started, err)
+	}
+	if st := s.state(); st.Active || !slices.Equal(storage.Texts(st.Items), []string{"a", "b"}) {
+		t.Errorf("unexpected state %+v", st)
+	}
+	if c.content != "user copy" {
+		t.Errorf("expected the clipboard of an inactive queue untouched, got %q", c.content)
+	}
+}
+
+// Items added together paste in the given order in stack mode too, as
+// they do when Capture splits a copy.
+func TestManager_AddAllStack(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Mode: storage.ModeStack, Items: items("old")})
+	mgr := NewManager(s, &MockClipboard{})
+
+	if _, err := mgr.AddAll([]string{"1", "2", "3"}, true); err != nil {
+		t.Fatal(err)
+	}
+	var pasted []string
+	for range 4 {
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pasted = append(pasted, item)
+	}
+	if !slices.Equal(pasted, []string{"1", "2", "3", "old"}) {
+		t.Errorf("expected the added items first, in order, got %q", pasted)
+	}
+
+	if _, err := mgr.ReplaceAll([]string{"x", "y"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if next, _ := mgr.Pop(); next != "x" {
+		t.Errorf("expected ReplaceAll to keep the order too, got %q first", next)
+	}
+}
+
+func TestManager_AddAllSavesOnce(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items()})
+	mgr := NewManager(s, &MockClipboard{})
+
+	splitter, _ := ParseSplitter("newline")
+	if _, err := mgr.AddAll(splitter.Split("id-1\nid-2\nid-3\n"), true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.saves != 1 {
+		t.Errorf("expected a single save, got %d", s.saves)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"id-1", "id-2", "id-3"}) {
+		t.Errorf("wrong items: %v", s.state().Items)
+	}
+}
+
+func TestManager_Capture(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items()})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	// Without split mode a block is a single item.
+	if err := mgr.Capture("a\nb"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.state().Items) != 1 {
+		t.Fatalf("expected one item, got %v", s.state().Items)
+	}
+
+	if err := mgr.SetSplitMode("newline"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.Capture("x\ny\nz"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"a\nb", "x", "y", "z"}) {
+		t.Errorf("wrong items in queue mode: %v", s.state().Items)
+	}
+
+	// In stack mode the parts are stored reversed so "x" still pastes first.
+	s.update(func(st *storage.State) {
+		st.Items = items()
+		st.Mode = storage.ModeStack
+	})
+	if err := mgr.Capture("x\ny\nz"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"z", "y", "x"}) {
+		t.Errorf("wrong items in stack mode: %v", s.state().Items)
+	}
+	if c.content != "x" {
+		t.Errorf("expected clipboard=x, got %q", c.content)
+	}
+
+	if err := mgr.SetSplitMode("bogus"); err == nil {
+		t.Error("expected error for unknown delimiter")
+	}
+}
+
+func TestManager_SplitAt(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("first", "a,b,c", "last")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+	comma, _ := ParseSplitter("comma")
+
+	n, err := mgr.SplitAt(1, comma)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 || !slices.Equal(storage.Texts(s.state().Items), []string{"first", "a", "b", "c", "last"}) {
+		t.Errorf("wrong FIFO split (%d): %v", n, s.state().Items)
+	}
+
+	// Stack mode: parts are reversed in storage and the new head is synced.
+	s.update(func(st *storage.State) {
+		st.Items = items("first", "a,b,c")
+		st.Mode = storage.ModeStack
+	})
+	if _, err := mgr.SplitAt(1, comma); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"first", "c", "b", "a"}) {
+		t.Errorf("wrong LIFO split: %v", s.state().Items)
+	}
+	if c.content != "a" {
+		t.Errorf("expected clipboard=a, got %q", c.content)
+	}
+
+	if n, err := mgr.SplitAt(0, comma); err != nil || n != 1 {
+		t.Errorf("splitting an item without delimiters: %d, %v", n, err)
+	}
+	if _, err := mgr.SplitAt(9, comma); err == nil {
+		t.Error("expected out of range error")
+	}
+}
+
+func TestManager_Join(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b", "c", "d")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	// A selection is joined in paste order at the position of its first item.
+	joined, err := mgr.Join([]int{3, 1}, ", ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if joined != "b, d" || !slices.Equal(storage.Texts(s.state().Items), []string{"a", "b, d", "c"}) {
+		t.Errorf("wrong selection join %q: %v", joined, s.state().Items)
+	}
+
+	// Whole queue with the default newline separator; the head is re-synced.
+	if _, err := mgr.Join(nil, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"a\nb, d\nc"}) {
+		t.Errorf("wrong full join: %v", s.state().Items)
+	}
+	if c.content != "a\nb, d\nc" {
+		t.Errorf("expected clipboard to hold the joined item, got %q", c.content)
+	}
+
+	// Stack mode joins top-down, and the configured template is used.
+	s.update(func(st *storage.State) {
+		st.Items = items("a", "b", "c")
+		st.Mode = storage.ModeStack
+	})
+	if err := mgr.SetJoinSeparator(`{{range $i, $e := .Items}}{{if $i}},{{end}}'{{$e}}'{{end}}`); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if joined, err := mgr.Join(nil, ""); err != nil || joined != "'c','b','a'" {
+		t.Errorf("wrong stack template join %q: %v", joined, err)
+	}
+
+	if err := mgr.SetJoinSeparator("{{.Nope"); err == nil {
+		t.Error("expected error for invalid template")
+	}
+	if _, err := mgr.Join([]int{5}, ","); err == nil {
+		t.Error("expected out of range error")
+	}
+}
+
+func TestManager_Transforms(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items()})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	// Capture transforms change what is stored; parts left empty are dropped.
+	if err := mgr.SetSplitMode("comma"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetTransforms(false, transform.Chain{"trim", "lower"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.Capture(" A ,\t,B"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Equal(storage.Texts(s.state().Items), []string{"a", "b"}) {
+		t.Errorf("wrong captured items: %v", s.state().Items)
+	}
+
+	// Paste transforms only change what goes on the clipboard.
+	if err := mgr.SetTransforms(true, transform.Chain{"sql-quote"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "'a'" {
+		t.Errorf("expected quoted head on clipboard, got %q", c.content)
+	}
+	if !mgr.Wrote("'a'") || mgr.Wrote("a") {
+		t.Error("Wrote should report the transformed value only")
+	}
+	if item, err := mgr.PopAndSync(); err != nil || item != "a" {
+		t.Errorf("expected to pop the stored item, got %q: %v", item, err)
+	}
+	if c.content != "'b'" {
+		t.Errorf("expected next quoted item on clipboard, got %q", c.content)
+	}
+
+	// Turning a stage off clears it.
+	if err := mgr.SetTransforms(true, nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.state().PasteTransforms != nil || c.content != "b" {
+		t.Errorf("expected paste transforms off, got %v and %q", s.state().PasteTransforms, c.content)
+	}
+}
+
+func TestResolveNumericID(t *testing.T) {
+	list := []storage.Item{{ID: "1234abcd", Text: "a"}, {ID: "ffff0000", Text: "b"}}
+	if i, err := resolve(list, "1"); err != nil || i != 1 {
+		t.Errorf("expected index 1, got %d: %v", i, err)
+	}
+	if i, err := resolve(list, "1234"); err != nil || i != 0 {
+		t.Errorf("expected a numeric ID prefix to match item 0, got %d: %v", i, err)
+	}
+	if _, err := resolve(list, "5"); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("expected out of range error, got %v", err)
+	}
+	if _, err := resolve(list, "12"); err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("expected a short number to be an index, got %v", err)
+	}
+}
+
+func TestManager_Template(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if err := mgr.SetTemplate("{{.Counter}}:{{.Text}}{{with .Meta.note}} ({{.}}){{end}}"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "1:a" {
+		t.Errorf("expected rendered head, got %q", c.content)
+	}
+	if err := mgr.SetMeta(s.state().Items[0].ID, "note", "first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "1:a (first)" {
+		t.Errorf("expected metadata in rendered head, got %q", c.content)
+	}
+
+	// The counter advances with every paste and the stored item is untouched.
+	if item, err := mgr.PopAndSync(); err != nil || item != "a" {
+		t.Errorf("expected to pop the stored item, got %q: %v", item, err)
+	}
+	if c.content != "2:b" || s.state().Pasted != 1 {
+		t.Errorf("expected second rendering, got %q after %d pastes", c.content, s.state().Pasted)
+	}
+
+	// Reactivating resets the counter.
+	if err := mgr.SetActive(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.state().Pasted != 0 {
+		t.Errorf("expected counter reset, got %d", s.state().Pasted)
+	}
+
+	if err := mgr.SetTemplate("{{.Text"); err == nil {
+		t.Error("expected error for invalid template")
+	}
+	if err := mgr.SetMeta("nope", "k", "v"); err == nil {
+		t.Error("expected error for unknown item")
+	}
+}
+
+func TestManager_Generator(t *testing.T) {
+	s := newTestStorage(&storage.State{Items: items("old")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if err := mgr.SetGenerator("id-{n:01..03}"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !s.state().Active || c.content != "id-01" || !slices.Equal(storage.Texts(s.state().Items), []string{"id-01"}) {
+		t.Fatalf("expected an active queue holding the first value, got %+v, clipboard %q", s.state(), c.content)
+	}
+
+	// Copies are not captured; every paste yields the next value.
+	if err := mgr.Capture("copied"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, want := range []string{"id-01", "id-02"} {
+		if item, err := mgr.PopAndSync(); err != nil || item != want {
+			t.Errorf("expected %q, got %q: %v", want, item, err)
+		}
+	}
+	if c.content != "id-03" {
+		t.Errorf("expected id-03 on the clipboard, got %q", c.content)
+	}
+	if item, err := mgr.PopAndSync(); err != nil || item != "id-03" {
+		t.Errorf("expected id-03, got %q: %v", item, err)
+	}
+	if _, err := mgr.PopAndSync(); err == nil {
+		t.Error("expected exhausted generator to leave the queue empty")
+	}
+
+	// Reactivating restarts the sequence.
+	if err := mgr.SetActive(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "id-01" {
+		t.Errorf("expected restart at id-01, got %q", c.content)
+	}
+
+	if err := mgr.SetGenerator(""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.state().Generator != "" || len(s.state().Items) != 0 {
+		t.Errorf("expected generator off and queue empty, got %+v", s.state())
+	}
+	if err := mgr.SetGenerator("{bogus}"); err == nil {
+		t.Error("expected error for invalid pattern")
+	}
+}
+
+func TestManager_GeneratorWithPins(t *testing.T) {
+	s := newTestStorage(&storage.State{Items: items("sig", "old")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+	if err := mgr.Pin(s.state().Items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pinned item stays and does not keep the generator from running.
+	if err := mgr.SetGenerator("id-{n:1..9}"); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.Texts(s.state().Items); !slices.Equal(got, []string{"sig", "id-1"}) {
+		t.Fatalf("expected the pin and the first value, got %q", got)
+	}
+	var pasted []string
+	for range 3 {
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pasted = append(pasted, item)
+	}
+	if want := []string{"sig", "id-1", "id-2"}; !slices.Equal(pasted, want) {
+		t.Errorf("got %v, want %v", pasted, want)
+	}
+
+	// Restarting with the pin pasted last puts the first value up next.
+	if err := mgr.SetPinPosition(storage.PinLast); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.SetActive(true); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.Texts(s.state().Items); !slices.Equal(got, []string{"sig", "id-1"}) || c.content != "id-1" {
+		t.Errorf("expected id-1 next after the restart, got %q, clipboard %q", got, c.content)
+	}
+
+	if err := mgr.SetGenerator(""); err != nil {
+		t.Fatal(err)
+	}
+	if got := storage.Texts(s.state().Items); !slices.Equal(got, []string{"sig"}) {
+		t.Errorf("expected only the pin left, got %q", got)
+	}
+}
+
+// Editing a cycling queue keeps the item that is due next.
+func TestManager_CycleEdits(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Cycle: true, Items: items("a", "b", "c", "d")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+	for range 2 {
+		if _, err := mgr.PopAndSync(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.content != "c" {
+		t.Fatalf("expected c next, got %q", c.content)
+	}
+
+	// Removing an item before the cursor must not skip c.
+	if _, err := mgr.DeleteAt(0); err != nil {
+		t.Fatal(err)
+	}
+	if c.content != "c" || s.state().Cursor != 1 {
+		t.Fatalf("expected c still next, got %q at cursor %d", c.content, s.state().Cursor)
+	}
+
+	// Inserting before the cursor must not repeat b; inserting at it makes
+	// the new item next, as it would without cycling.
+	if err := mgr.InsertAt(0, "x"); err != nil {
+		t.Fatal(err)
+	}
+	if c.content != "c" {
+		t.Errorf("expected c still next after inserting before it, got %q", c.content)
+	}
+	if err := mgr.InsertAt(2, "y"); err != nil {
+		t.Fatal(err)
+	}
+	if c.content != "y" {
+		t.Errorf("expected the item inserted at the cursor next, got %q", c.content)
+	}
+	if _, err := mgr.DeleteAt(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Moving the last item in front of the cursor skips it this round.
+	if err := mgr.Move(3, 0); err != nil {
+		t.Fatal(err)
+	}
+	var pasted []string
+	for range 4 {
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatal(err)
+		}
+		pasted = append(pasted, item)
+	}
+	if want := []string{"c", "d", "x", "b"}; !slices.Equal(pasted, want) {
+		t.Errorf("got %v, want %v", pasted, want)
+	}
+
+	// Removing the next item and everything after it wraps around.
+	if _, err := mgr.DeleteAt(3); err != nil { // c, next again
+		t.Fatal(err)
+	}
+	if c.content != "d" || s.state().Cursor >= len(s.state().Items) {
+		t.Errorf("expected to wrap around to d, got %q at cursor %d", c.content, s.state().Cursor)
+	}
+
+	// Clearing starts over.
+	if err := mgr.SetRepeat(s.state().Items[0].ID, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mgr.PopAndSync(); err != nil {
+		t.Fatal(err)
+	}
+	if err := mgr.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if st := s.state(); len(st.Items) != 0 || st.Cursor != 0 || st.Repeated != 0 {
+		t.Errorf("expected cursor and repeats reset, got %+v", st)
+	}
+}
+
+func TestManager_CycleAndRepeat(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b", "c")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	if err := mgr.SetCycleMode(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetRepeat(s.state().Items[1].ID, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var pasted []string
+	for range 7 {
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pasted = append(pasted, item)
+	}
+	if want := []string{"a", "b", "b", "c", "a", "b", "b"}; !slices.Equal(pasted, want) {
+		t.Errorf("got %v, want %v", pasted, want)
+	}
+	if len(s.state().Items) != 3 || c.content != "c" {
+		t.Errorf("expected all items kept and c next, got %v, clipboard %q", s.state().Items, c.content)
+	}
+
+	// Stack mode cycles from the top.
+	if err := mgr.SetMode(storage.ModeStack); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetCycleMode(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if NextIndex(s.state()) != 2 || c.content != "c" {
+		t.Errorf("expected top item next, got index %d, clipboard %q", NextIndex(s.state()), c.content)
+	}
+
+	// Without cycling, repeated items are pasted their number of times and
+	// then removed.
+	if err := mgr.SetMode(storage.ModeQueue); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetCycleMode(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	pasted = nil
+	for range 4 {
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pasted = append(pasted, item)
+	}
+	if want := []string{"a", "b", "b", "c"}; !slices.Equal(pasted, want) || len(s.state().Items) != 0 {
+		t.Errorf("got %v leaving %v, want %v", pasted, s.state().Items, want)
+	}
+}
+
+func TestManager_RepeatResetsWhenHeadChanges(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "b")})
+	mgr := NewManager(s, &MockClipboard{})
+
+	if err := mgr.SetRepeat(s.state().Items[0].ID, 3); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetRepeat(s.state().Items[1].ID, 2); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := mgr.PopAndSync(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := mgr.DeleteAt(0); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.state().Repeated != 0 {
+		t.Errorf("expected repeat count reset for the new head, got %d", s.state().Repeated)
+	}
+}
+
+func TestManager_Pins(t *testing.T) {
+	s := newTestStorage(&storage.State{Active: true, Items: items("a", "sig", "b")})
+	c := &MockClipboard{}
+	mgr := NewManager(s, c)
+
+	sig := s.state().Items[1]
+	if err := mgr.Pin(sig.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.content != "sig" {
+		t.Errorf("expected pinned item pasted first, got %q", c.content)
+	}
+
+	// Edits to a pinned item are kept in the pin.
+	if err := mgr.EditItem(sig.ID, "sig", "-- sig"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mgr.SetPinPosition(storage.PinLast); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var pasted []string
+	for range 3 {
+		item, err := mgr.PopAndSync()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		pasted = append(pasted, item)
+	}
+	if want := []string{"a", "b", "-- sig"}; !slices.Equal(pasted, want) {
+		t.Errorf("got %v, want %v", pasted, want)
+	}
+
+	// Pins survive activation, deactivation and clearing.
+	for _, reset := range []func() error{
+		func() error { return mgr.SetActive(true) },
+		func() error { return mgr.SetActive(false) },
+		mgr.Clear,
+	} {
+		if err := reset(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !slices.Equal(storage.Texts(s.state().Items), []string{"-- sig"}) || !s.state().Items[0].Pinned {
+			t.Errorf("expected the pinned item back, got %+v", s.state().Items)
+		}
+	}
+
+	if text, err := mgr.Unpin(sig.ID[:5]); err != nil || text != "-- sig" {
+		t.Fatalf("unpin: %q, %v", text, err)
+	}
+	if s.state().Items[0].Pinned || len(s.state().Pins) != 0 {
+		t.Errorf("expected item unpinned, got %+v, pins %v", s.state().Items, s.state().Pins)
+	}
+	if err := mgr.SetActive(true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.state().Items) != 0 {
+		t.Errorf("expected empty queue after unpinning, got %v", s.state().Items)
+	}
+	if _, err := mgr.Unpin("zzzz"); err == nil {
+		t.Error("expected error for unknown pin")
+	}
+	if err := mgr.SetPinPosition("middle"); err == nil {
+		t.Error("expected error for unknown position")
+	}
+}
+
+// TestManager_ConcurrentManagers runs several managers, as separate
+// processes would, against one state file. Without the storage lock their
+// read-modify-write cycles interleave and items get lost.
+func TestManager_ConcurrentManagers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	const workers, adds = 4, 25
+
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			mgr := NewManager(storage.NewJSONStorage(path), &MockClipboard{})
+			for i := range adds {
+				if _, err := mgr.AddAll([]string{fmt.Sprintf("w%d-%d", w, i)}, true); err != nil {
+					t.Errorf("worker %d: %v", w, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	checkAllAdded(t, path, workers, adds)
+}
+
+// TestManager_ConcurrentProcesses does the same with real processes,
+// re-running the test binary as TestHelperAddProcess.
+func TestManager_ConcurrentProcesses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	const workers, adds = 4, 25
+
+	cmds := make([]*exec.Cmd, workers)
+	for w := range cmds {
+		cmds[w] = exec.Command(os.Args[0], "-test.run=^TestHelperAddProcess$")
+		cmds[w].Env = append(os.Environ(),
+			"CBQ_HELPER_STATE="+path,
+			fmt.Sprintf("CBQ_HELPER_WORKER=%d", w),
+			fmt.Sprintf("CBQ_HELPER_ADDS=%d", adds))
+		if err := cmds[w].Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for w, cmd := range cmds {
+		if err := cmd.Wait(); err != nil {
+			t.Errorf("helper %d: %v", w, err)
+		}
+	}
+	checkAllAdded(t, path, workers, adds)
+}
+
+func TestHelperAddProcess(t *testing.T) {
+	path := os.Getenv("CBQ_HELPER_STATE")
+	if path == "" {
+		t.Skip("only run as a helper process")
+	}
+	w, _ := strconv.Atoi(os.Getenv("CBQ_HELPER_WORKER"))
+	adds, _ := strconv.Atoi(os.Getenv("CBQ_HELPER_ADDS"))
+	mgr := NewManager(storage.NewJSONStorage(path), &MockClipboard{})
+	for i := range adds {
+		if _, err := mgr.AddAll([]string{fmt.Sprintf("w%d-%d", w, i)}, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkAllAdded(t *testing.T, path string, workers, adds int) {
+	t.Helper()
+	state, err := storage.NewJSONStorage(path).Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, item := range state.Items {
+		got[item.Text] = true
+	}
+	for w := range workers {
+		for i := range adds {
+			if text := fmt.Sprintf("w%d-%d", w, i); !got[text] {
+				t.Errorf("lost %s", text)
+			}
+		}
+	}
+	if len(state.Items) != workers*adds {
+		t.Errorf("expected %d items, got %d", workers*adds, len(state.Items))
+	}
+}
+
+func TestManager_RefreshAfterExternalChange(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	c := &MockClipboard{}
+	mgr := NewManager(storage.NewJSONStorage(path), c)
+	if _, err := mgr.AddAll([]string{"a", "b"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, err := mgr.Refresh(); err != nil || changed {
+		t.Errorf("expected no change, got %v: %v", changed, err)
+	}
+
+	// Rewrite the file behind the Manager's back, as a hand edit would.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edited := strings.Replace(string(data), `"text": "a"`, `"text": "edited"`, 1)
+	if err := os.WriteFile(path, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, changed, err := mgr.Refresh()
+	if err != nil || !changed {
+		t.Fatalf("expected the change to be detected, got %v: %v", changed, err)
+	}
+	if c.content != "edited" {
+		t.Errorf("expected the clipboard re-synced, got %q", c.content)
+	}
+	state, err := mgr.GetStatus()
+	if err != nil || state.Items[0].Text != "edited" {
+		t.Errorf("expected the edit in the cached state, got %+v: %v", state, err)
+	}
+
+	// A change that leaves the next item alone does not touch the clipboard.
+	c.content = "user copy"
+	other := NewManager(storage.NewJSONStorage(path), &MockClipboard{})
+	if _, err := other.AddAll([]string{"c"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, changed, err := mgr.Refresh(); err != nil || !changed {
+		t.Fatalf("expected the change to be detected, got %v: %v", changed, err)
+	}
+	if c.content != "user copy" {
+		t.Errorf("expected clipboard left alone, got %q", c.content)
 	}
 }
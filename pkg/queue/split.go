@@ -0,0 +1,57 @@
+package queue
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Splitter breaks one piece of text into several items.
+type Splitter struct {
+	spec string
+	re   *regexp.Regexp
+}
+
+// namedSplitters are the delimiters that can be given by name.
+var namedSplitters = map[string]string{
+	"newline": `\r?\n`,
+	"nul":     `\x00`,
+	"comma":   `\s*,\s*`,
+	"tab":     `\t`,
+}
+
+// ParseSplitter builds a Splitter from a spec: newline, nul, comma, tab,
+// or re:<pattern> for a custom regular expression.
+func ParseSplitter(spec string) (Splitter, error) {
+	pattern, ok := namedSplitters[spec]
+	if !ok {
+		var found bool
+		if pattern, found = strings.CutPrefix(spec, "re:"); !found {
+			return Splitter{}, fmt.Errorf("unknown delimiter %q (want newline, nul, comma, tab or re:<pattern>)", spec)
+		}
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return Splitter{}, fmt.Errorf("invalid delimiter pattern: %w", err)
+	}
+	if re.MatchString("") {
+		return Splitter{}, fmt.Errorf("delimiter pattern %q matches the empty string", pattern)
+	}
+	return Splitter{spec: spec, re: re}, nil
+}
+
+// String returns the spec the Splitter was parsed from.
+func (s Splitter) String() string {
+	return s.spec
+}
+
+// Split returns the non-empty pieces of text between delimiters.
+func (s Splitter) Split(text string) []string {
+	var out []string
+	for _, part := range s.re.Split(text, -1) {
+		if part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
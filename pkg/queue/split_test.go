@@ -0,0 +1,35 @@
+package queue
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitter(t *testing.T) {
+	cases := []struct {
+		spec, in string
+		want     []string
+	}{
+		{"newline", "a\nb\r\nc\n\n", []string{"a", "b", "c"}},
+		{"nul", "a\x00b c\x00", []string{"a", "b c"}},
+		{"comma", "1, 2,3 ,,4", []string{"1", "2", "3", "4"}},
+		{"tab", "x\ty", []string{"x", "y"}},
+		{"re:\\s*;\\s*", "a ; b;c", []string{"a", "b", "c"}},
+		{"newline", "", nil},
+	}
+	for _, tc := range cases {
+		s, err := ParseSplitter(tc.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		if got := s.Split(tc.in); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.spec, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"semicolon", "re:(", "re:x*"} {
+		if _, err := ParseSplitter(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
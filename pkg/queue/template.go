@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/transform"
+)
+
+// PasteData is what a paste template is executed with.
+type PasteData struct {
+	Text    string            // the item, after the paste transformations
+	Index   int               // items pasted since the queue was activated
+	Counter int               // Index + 1, for numbering from one
+	Time    time.Time         // when the item is put on the clipboard
+	Created time.Time         // when the item was captured
+	Meta    map[string]string // the item's metadata
+}
+
+// renderPaste executes the paste template spec. The transformations are
+// available as functions, e.g. {{sqlQuote .Text}}.
+func renderPaste(spec string, data PasteData) (string, error) {
+	tmpl, err := template.New("paste").Funcs(transform.TemplateFuncs()).Option("missingkey=zero").Parse(spec)
+	if err != nil {
+		return "", fmt.Errorf("invalid paste template: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("paste template: %w", err)
+	}
+	return b.String(), nil
+}
+
+// ValidateTemplate reports whether spec is a usable paste template.
+func ValidateTemplate(spec string) error {
+	_, err := renderPaste(spec, PasteData{Text: "text", Counter: 1, Time: time.Now(), Created: time.Now()})
+	return err
+}
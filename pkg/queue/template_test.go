@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenderPaste(t *testing.T) {
+	data := PasteData{
+		Text:    "O'Brien",
+		Index:   4,
+		Counter: 5,
+		Created: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Meta:    map[string]string{"table": "users"},
+	}
+	cases := []struct{ spec, want string }{
+		{"INSERT INTO {{.Meta.table}} VALUES ({{sqlQuote .Text}})", "INSERT INTO users VALUES ('O''Brien')"},
+		{"{{.Counter}}. {{upper .Text}}", "5. O'BRIEN"},
+		{"{{.Index}} {{.Created.Format \"2006-01-02\"}}", "4 2024-03-01"},
+		{"[{{.Meta.missing}}]", "[]"},
+	}
+	for _, tc := range cases {
+		got, err := renderPaste(tc.spec, data)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.spec, err)
+		}
+		if got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.spec, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"{{.Text", "{{.Nope}}", "{{rot13 .Text}}"} {
+		if err := ValidateTemplate(bad); err == nil {
+			t.Errorf("expected error for %q", bad)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+// Package snippet keeps a persistent library of named text snippets, and
+// named groups of them, that can be queued on demand.
+package snippet
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+// Library maps snippet names to their text and group names to the
+// snippets they contain, in order.
+type Library struct {
+	Snippets map[string]string   `json:"snippets"`
+	Groups   map[string][]string `json:"groups,omitempty"`
+}
+
+// Store reads and writes a Library as a JSON file.
+type Store struct {
+	Path string
+}
+
+func NewStore(path string) *Store {
+	return &Store{Path: path}
+}
+
+// DefaultPath returns snippets.json next to the default state file.
+func DefaultPath() (string, error) {
+	state, err := storage.GetDefaultPath()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(state), "snippets.json"), nil
+}
+
+// Load reads the library; a missing file is an empty library.
+func (s *Store) Load() (*Library, error) {
+	lib := &Library{Snippets: map[string]string{}}
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return lib, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, lib); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+	if lib.Snippets == nil {
+		lib.Snippets = map[string]string{}
+	}
+	return lib, nil
+}
+
+// Save writes the library atomically.
+func (s *Store) Save(lib *Library) error {
+	data, err := json.MarshalIndent(lib, "", "  ")
+	if err != nil {
+		return err
+	}
+	return storage.WriteFileAtomic(s.Path, data)
+}
+
+// Set adds or replaces a snippet.
+func (l *Library) Set(name, text string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, ok := l.Groups[name]; ok {
+		return fmt.Errorf("%q is already a group", name)
+	}
+	l.Snippets[name] = text
+	return nil
+}
+
+// Remove deletes a snippet or a group. A removed snippet is also taken out
+// of every group; groups left empty are deleted.
+func (l *Library) Remove(name string) error {
+	if _, ok := l.Groups[name]; ok {
+		delete(l.Groups, name)
+		return nil
+	}
+	if _, ok := l.Snippets[name]; !ok {
+		return fmt.Errorf("no snippet or group %q", name)
+	}
+	delete(l.Snippets, name)
+	for group, members := range l.Groups {
+		members = slices.DeleteFunc(members, func(m string) bool { return m == name })
+		if len(members) == 0 {
+			delete(l.Groups, group)
+		} else {
+			l.Groups[group] = members
+		}
+	}
+	return nil
+}
+
+// SetGroup defines a group of existing snippets, replacing any group of
+// the same name.
+func (l *Library) SetGroup(name string, members []string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, ok := l.Snippets[name]; ok {
+		return fmt.Errorf("%q is already a snippet", name)
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("group %q needs at least one snippet", name)
+	}
+	for _, m := range members {
+		if _, ok := l.Snippets[m]; !ok {
+			return fmt.Errorf("no snippet %q", m)
+		}
+	}
+	if l.Groups == nil {
+		l.Groups = map[string][]string{}
+	}
+	l.Groups[name] = slices.Clone(members)
+	return nil
+}
+
+// Resolve returns the text of every named snippet, with groups expanded to
+// their snippets, in order.
+func (l *Library) Resolve(names ...string) ([]string, error) {
+	var texts []string
+	for _, name := range names {
+		if members, ok := l.Groups[name]; ok {
+			for _, m := range members {
+				texts = append(texts, l.Snippets[m])
+			}
+			continue
+		}
+		text, ok := l.Snippets[name]
+		if !ok {
+			return nil, fmt.Errorf("no snippet or group %q", name)
+		}
+		texts = append(texts, text)
+	}
+	return texts, nil
+}
+
+// Names returns the snippet names, sorted.
+func (l *Library) Names() []string {
+	return sortedKeys(l.Snippets)
+}
+
+// GroupNames returns the group names, sorted.
+func (l *Library) GroupNames() []string {
+	return sortedKeys(l.Groups)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func checkName(name string) error {
+	if name == "" || strings.ContainsFunc(name, func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' }) {
+		return fmt.Errorf("invalid name %q: must be non-empty without spaces", name)
+	}
+	return nil
+}
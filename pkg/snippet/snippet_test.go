@@ -0,0 +1,87 @@
+package snippet
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestStore_SaveAndLoad(t *testing.T) {
+	store := NewStore(filepath.Join(t.TempDir(), "cbq", "snippets.json"))
+
+	empty, err := store.Load()
+	if err != nil {
+		t.Fatalf("failed to load missing library: %v", err)
+	}
+	if len(empty.Snippets) != 0 {
+		t.Errorf("expected empty library, got %v", empty.Snippets)
+	}
+
+	if err := empty.Set("sig", "-- \nJane"); err != nil {
+		t.Fatal(err)
+	}
+	if err := empty.Set("prefix", "PROJ-"); err != nil {
+		t.Fatal(err)
+	}
+	if err := empty.SetGroup("mail", []string{"prefix", "sig"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(empty); err != nil {
+		t.Fatalf("failed to save: %v", err)
+	}
+
+	lib, err := store.Load()
+	if err != nil {
+		t.Fatalf("failed to load: %v", err)
+	}
+	if !slices.Equal(lib.Names(), []string{"prefix", "sig"}) || !slices.Equal(lib.GroupNames(), []string{"mail"}) {
+		t.Errorf("library not round-tripped: %+v", lib)
+	}
+}
+
+func TestLibrary(t *testing.T) {
+	lib := &Library{Snippets: map[string]string{}}
+	for name, text := range map[string]string{"a": "A", "b": "B", "c": "C"} {
+		if err := lib.Set(name, text); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := lib.SetGroup("ab", []string{"b", "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	texts, err := lib.Resolve("c", "ab")
+	if err != nil || !slices.Equal(texts, []string{"C", "B", "A"}) {
+		t.Errorf("Resolve = %v, %v", texts, err)
+	}
+	if _, err := lib.Resolve("nope"); err == nil {
+		t.Error("expected error for unknown name")
+	}
+
+	// Removing a snippet takes it out of its groups, dropping empty ones.
+	if err := lib.SetGroup("just-a", []string{"a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := lib.Remove("a"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(lib.Groups["ab"], []string{"b"}) {
+		t.Errorf("expected a removed from group, got %v", lib.Groups["ab"])
+	}
+	if _, ok := lib.Groups["just-a"]; ok {
+		t.Error("expected empty group to be deleted")
+	}
+
+	for _, err := range []error{
+		lib.Set("has space", "x"),
+		lib.Set("ab", "x"),
+		lib.SetGroup("b", []string{"c"}),
+		lib.SetGroup("g", []string{"missing"}),
+		lib.SetGroup("g", nil),
+		lib.Remove("missing"),
+	} {
+		if err == nil {
+			t.Error("expected an error")
+		}
+	}
+}
@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultBlobThreshold is the size in bytes above which an item's text is
+// moved into a blob file unless configured otherwise.
+const DefaultBlobThreshold = 64 << 10
+
+// Compression is how new blobs are compressed.
+type Compression string
+
+const (
+	NoCompression Compression = ""
+	Gzip          Compression = "gzip"
+	Zstd          Compression = "zstd"
+)
+
+// blobSuffixes maps each compression to the suffix of its blob names.
+var blobSuffixes = map[Compression]string{NoCompression: "", Gzip: ".gz", Zstd: ".zst"}
+
+// ParseCompression validates a compression.
+func ParseCompression(s string) (Compression, error) {
+	c := Compression(s)
+	if _, ok := blobSuffixes[c]; !ok {
+		return "", fmt.Errorf("unknown compression %q (want gzip or zstd)", s)
+	}
+	return c, nil
+}
+
+// UnmarshalJSON also accepts true for Gzip and false for none, as
+// compress_blobs used to be a boolean.
+func (c *Compression) UnmarshalJSON(data []byte) error {
+	var on bool
+	if err := json.Unmarshal(data, &on); err == nil {
+		*c = NoCompression
+		if on {
+			*c = Gzip
+		}
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("compression: %w", err)
+	}
+	parsed, err := ParseCompression(s)
+	if err != nil {
+		return err
+	}
+	*c = parsed
+	return nil
+}
+
+// BlobStorage keeps the text of large items out of the storage it wraps,
+// which then only has to write a reference, in files in Dir named by the
+// SHA-256 of the text, with ".gz" if gzip- or ".zst" if zstd-compressed.
+// Identical texts share a file. A save deletes the blobs that neither the new state nor the one
+// it replaces refers to, so the replaced state, such as the backup of a
+// JSONStorage, can still be loaded, whichever process saved it.
+type BlobStorage struct {
+	Base Storage
+	Dir  string
+	// Threshold is the text size in bytes above which an item is moved
+	// into a blob.
+	Threshold int
+	// Compress is how new blobs are compressed. Blobs are read whichever
+	// way they were written.
+	Compress Compression
+	// SnapshotDir, if set, holds snapshots whose blobs are kept as well.
+	SnapshotDir string
+
+	mu     sync.Mutex
+	stored map[string]bool // blobs the state last loaded or saved refers to, nil if unknown
+}
+
+func NewBlobStorage(base Storage, dir string, threshold int, compress Compression) *BlobStorage {
+	return &BlobStorage{Base: base, Dir: dir, Threshold: threshold, Compress: compress}
+}
+
+// Load loads the state from the wrapped storage and reads the text of
+// items kept in blobs.
+func (s *BlobStorage) Load() (*State, error) {
+	state, err := s.Base.Load()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.stored = blobRefs(state)
+	s.mu.Unlock()
+	if err := s.inflate(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// inflate reads the text of the items in state kept in blobs.
+func (s *BlobStorage) inflate(state *State) error {
+	for _, items := range [][]Item{state.Items, state.Pins, state.History} {
+		for i := range items {
+			if items[i].Blob == "" {
+				continue
+			}
+			text, err := s.read(items[i].Blob)
+			if err != nil {
+				return fmt.Errorf("item %s: %w", items[i].ID, err)
+			}
+			items[i].Text, items[i].Blob = text, ""
+		}
+	}
+	return nil
+}
+
+// Save moves large items into blobs, saves the state with references to
+// them through the wrapped storage, and deletes blobs no longer needed.
+func (s *BlobStorage) Save(state *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out, refs, err := s.deflateState(state)
+	if err != nil {
+		return err
+	}
+	replaced := s.storedRefs()
+	if err := s.Base.Save(out); err != nil {
+		return err
+	}
+	s.stored = refs
+	if replaced == nil {
+		return nil
+	}
+	return s.collect(refs, replaced)
+}
+
+// storedRefs returns the blobs the state in the wrapped storage refers to,
+// reading it again unless the storage can tell it is unchanged since s
+// last loaded or saved it, or nil if it cannot be read.
+// Must be called with s.mu held.
+func (s *BlobStorage) storedRefs() map[string]bool {
+	if d, ok := s.Base.(ChangeDetector); ok && s.stored != nil {
+		if changed, err := d.Changed(); err == nil && !changed {
+			return s.stored
+		}
+	}
+	state, err := s.Base.Load()
+	if err != nil {
+		return nil
+	}
+	return blobRefs(state)
+}
+
+// blobRefs returns the blobs state's items refer to.
+func blobRefs(state *State) map[string]bool {
+	refs := map[string]bool{}
+	for _, items := range [][]Item{state.Items, state.Pins, state.History} {
+		for _, item := range items {
+			if item.Blob != "" {
+				refs[item.Blob] = true
+			}
+		}
+	}
+	return refs
+}
+
+// deflateState returns a copy of state with every text above the threshold
+// replaced by a reference to its blob, and the blobs it refers to.
+func (s *BlobStorage) deflateState(state *State) (*State, map[string]bool, error) {
+	refs := map[string]bool{}
+	out := *state
+	var err error
+	if out.Items, err = s.deflate(state.Items, refs); err != nil {
+		return nil, nil, err
+	}
+	if out.Pins, err = s.deflate(state.Pins, refs); err != nil {
+		return nil, nil, err
+	}
+	if out.History, err = s.deflate(state.History, refs); err != nil {
+		return nil, nil, err
+	}
+	return &out, refs, nil
+}
+
+// deflate returns items with every text above the threshold replaced by a
+// reference to its blob, adding the blobs to refs.
+func (s *BlobStorage) deflate(items []Item, refs map[string]bool) ([]Item, error) {
+	var out []Item
+	for i, item := range items {
+		if len(item.Text) <= s.Threshold {
+			continue
+		}
+		if out == nil {
+			out = append([]Item(nil), items...)
+		}
+		name, err := s.write(item.Text)
+		if err != nil {
+			return nil, err
+		}
+		out[i].Text, out[i].Blob = "", name
+		refs[name] = true
+	}
+	if out == nil {
+		return items, nil
+	}
+	return out, nil
+}
+
+// write stores text in a blob, unless one holds it already, and returns
+// the blob's name.
+func (s *BlobStorage) write(text string) (string, error) {
+	sum := sha256.Sum256([]byte(text))
+	name := hex.EncodeToString(sum[:])
+	for _, suffix := range blobSuffixes {
+		if _, err := os.Stat(filepath.Join(s.Dir, name+suffix)); err == nil {
+			return name + suffix, nil
+		}
+	}
+	data, err := compress(s.Compress, []byte(text))
+	if err != nil {
+		return "", err
+	}
+	name += blobSuffixes[s.Compress]
+	return name, WriteFileAtomic(filepath.Join(s.Dir, name), data)
+}
+
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case Gzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Zstd:
+		zw, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zw.Close()
+		return zw.EncodeAll(data, nil), nil
+	}
+	return data, nil
+}
+
+func decompress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case Gzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return io.ReadAll(zr)
+	case Zstd:
+		zr, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return zr.DecodeAll(data, nil)
+	}
+	return data, nil
+}
+
+// read returns the text in a blob, checking it against the blob's name.
+func (s *BlobStorage) read(name string) (string, error) {
+	hash, codec := name, NoCompression
+	for c, suffix := range blobSuffixes {
+		if trimmed, ok := strings.CutSuffix(name, suffix); ok && suffix != "" {
+			hash, codec = trimmed, c
+		}
+	}
+	if filepath.Base(name) != name {
+		return "", fmt.Errorf("invalid blob name %q", name)
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir, name))
+	if err != nil {
+		return "", err
+	}
+	if data, err = decompress(codec, data); err != nil {
+		return "", fmt.Errorf("blob %s: %w", name, err)
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		return "", fmt.Errorf("blob %s is damaged", name)
+	}
+	return string(data), nil
+}
+
+// collect deletes the blobs in neither refs nor replaced, nor referred to
+// by a snapshot in SnapshotDir.
+func (s *BlobStorage) collect(refs, replaced map[string]bool) error {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var garbage []string
+	for _, e := range entries {
+		name := e.Name()
+		if refs[name] || replaced[name] || strings.HasPrefix(name, ".") {
+			continue // in use, or a temp file being written
+		}
+		garbage = append(garbage, name)
+	}
+	if len(garbage) > 0 && s.SnapshotDir != "" {
+		snapshots, err := (&Snapshots{Dir: s.SnapshotDir}).blobRefs()
+		if err != nil {
+			return err
+		}
+		garbage = slices.DeleteFunc(garbage, func(name string) bool { return snapshots[name] })
+	}
+	for _, name := range garbage {
+		if err := os.Remove(filepath.Join(s.Dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Clear empties the queue through the wrapped storage.
+func (s *BlobStorage) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stored = nil
+	return s.Base.Clear()
+}
+
+// Lock takes the wrapped storage's lock, if it has one.
+func (s *BlobStorage) Lock() (unlock func(), err error) {
+	if l, ok := s.Base.(Locker); ok {
+		return l.Lock()
+	}
+	return func() {}, nil
+}
+
+// Changed asks the wrapped storage, and reports a change if it cannot
+// tell.
+func (s *BlobStorage) Changed() (bool, error) {
+	if d, ok := s.Base.(ChangeDetector); ok {
+		return d.Changed()
+	}
+	return true, nil
+}
+
+// CreatedBetween looks the items up through the wrapped storage (see the
+// function CreatedBetween) and reads the text of those kept in blobs.
+func (s *BlobStorage) CreatedBetween(from, to time.Time) ([]Item, error) {
+	s.mu.Lock()
+	items, err := CreatedBetween(s.Base, from, to)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.inflate(&State{Items: items}); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// Unwrap returns the storage s wraps.
+func (s *BlobStorage) Unwrap() Storage {
+	return s.Base
+}
+
+// Unwrap returns the storage at the bottom of any middleware such as
+// BlobStorage, or s itself.
+func Unwrap(s Storage) Storage {
+	for {
+		u, ok := s.(interface{ Unwrap() Storage })
+		if !ok {
+			return s
+		}
+		s = u.Unwrap()
+	}
+}
@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobStorage(t *testing.T) {
+	for _, compress := range []Compression{NoCompression, Gzip, Zstd} {
+		dir := t.TempDir()
+		base := NewJSONStorage(filepath.Join(dir, "state.json"))
+		s := NewBlobStorage(base, filepath.Join(dir, "blobs"), 100, compress)
+
+		big, other, pasted := strings.Repeat("big ", 100), strings.Repeat("other ", 100), strings.Repeat("pasted ", 100)
+		state := &State{Items: []Item{NewItem("small"), NewItem(big), NewItem(big)}, Pins: []Item{NewItem(other)}, History: []Item{NewItem(pasted)}}
+		if err := s.Save(state); err != nil {
+			t.Fatal(err)
+		}
+		if state.Items[1].Text != big || state.Items[1].Blob != "" {
+			t.Error("Save changed the caller's state")
+		}
+
+		// The wrapped storage holds references; identical texts share a blob.
+		stored, err := base.Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ref := stored.Items[1].Blob
+		if stored.Items[0].Blob != "" || stored.Items[1].Text != "" || ref == "" || stored.Items[2].Blob != ref {
+			t.Errorf("unexpected stored items %+v", stored.Items)
+		}
+		if !strings.HasSuffix(ref, blobSuffixes[compress]) || len(ref) != 64+len(blobSuffixes[compress]) {
+			t.Errorf("blob %s, compress %q", ref, compress)
+		}
+
+		// Blobs are read whichever way new ones are compressed.
+		got, err := NewBlobStorage(base, s.Dir, 100, Zstd).Load()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Texts(got.Items)[1] != big || got.Pins[0].Text != other || got.History[0].Text != pasted || got.Items[1].Blob != "" {
+			t.Errorf("blobs not read back: %+v", got)
+		}
+
+		// A blob is deleted after two saves without it.
+		state.Items = state.Items[:1]
+		for i := range 2 {
+			if err := s.Save(state); err != nil {
+				t.Fatal(err)
+			}
+			_, err := os.Stat(filepath.Join(s.Dir, ref))
+			if exists := err == nil; exists != (i == 0) {
+				t.Errorf("after %d saves without it, blob exists: %v", i+1, exists)
+			}
+		}
+		if entries, _ := os.ReadDir(s.Dir); len(entries) != 2 {
+			t.Errorf("expected only the blobs of the pinned and the pasted item left, got %d files", len(entries))
+		}
+	}
+}
+
+func TestBlobStorage_Damaged(t *testing.T) {
+	dir := t.TempDir()
+	base := NewJSONStorage(filepath.Join(dir, "state.json"))
+	s := NewBlobStorage(base, filepath.Join(dir, "blobs"), 0, NoCompression)
+	if err := s.Save(&State{Items: []Item{NewItem("text")}}); err != nil {
+		t.Fatal(err)
+	}
+	stored, _ := base.Load()
+	os.WriteFile(filepath.Join(s.Dir, stored.Items[0].Blob), []byte("changed"), 0644)
+	if _, err := s.Load(); err == nil || !strings.Contains(err.Error(), "damaged") {
+		t.Errorf("expected a damaged blob to be reported, got %v", err)
+	}
+}
+
+func TestBlobStorage_TwoProcesses(t *testing.T) {
+	dir := t.TempDir()
+	path, blobs := filepath.Join(dir, "state.json"), filepath.Join(dir, "blobs")
+	monitor := NewBlobStorage(NewJSONStorage(path), blobs, 10, NoCompression)
+	cli := NewBlobStorage(NewJSONStorage(path), blobs, 10, NoCompression)
+
+	x, y, z := strings.Repeat("x", 20), strings.Repeat("y", 20), strings.Repeat("z", 20)
+	for _, step := range []struct {
+		s    *BlobStorage
+		text string
+	}{{monitor, x}, {cli, y}, {monitor, z}} {
+		if err := step.s.Save(&State{Items: []Item{NewItem(step.text)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// state.json.bak holds the cli's save, which the monitor replaced.
+	os.WriteFile(path, []byte("{damaged"), 0644)
+	got, err := NewBlobStorage(NewJSONStorage(path), blobs, 10, NoCompression).Load()
+	if err != nil {
+		t.Fatalf("recovering from the backup: %v", err)
+	}
+	if len(got.Items) != 1 || got.Items[0].Text != y {
+		t.Errorf("expected the backup's item, got %+v", got.Items)
+	}
+}
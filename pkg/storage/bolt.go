@@ -0,0 +1,360 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DefaultQueue is the queue a BoltStorage uses unless told otherwise.
+const DefaultQueue = "default"
+
+// Bucket layout: queues/<name>/ holds the state without its items under
+// the key "state", the items bucket (position → item) and the created
+// bucket indexing items by time (created time + ID → position).
+var (
+	queuesBucket  = []byte("queues")
+	stateKey      = []byte("state")
+	itemsBucket   = []byte("items")
+	createdBucket = []byte("created")
+)
+
+// BoltStorage stores state in a bbolt database, one bucket per named
+// queue, with each item stored and indexed by creation time separately so
+// a save only writes the items that changed. Items are keyed by position;
+// items added at the end get the next key, anything else renumbers the
+// queue.
+//
+// bbolt locks the database file while it is open, so it is only kept open
+// while Lock is held, and otherwise opened for each call.
+type BoltStorage struct {
+	Path string
+	// Queue names the queue to load and save; empty means DefaultQueue.
+	Queue string
+
+	mu    sync.Mutex
+	db    *bolt.DB // open while locked
+	txid  int      // the transaction state was read or written in
+	state *State   // nil if unknown
+	keys  []uint64 // the position key of each of state's items
+}
+
+func NewBoltStorage(path, queue string) *BoltStorage {
+	return &BoltStorage{Path: path, Queue: queue}
+}
+
+func (s *BoltStorage) queue() []byte {
+	if s.Queue == "" {
+		return []byte(DefaultQueue)
+	}
+	return []byte(s.Queue)
+}
+
+func openBolt(path string) (*bolt.DB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return bolt.Open(path, 0600, nil)
+}
+
+// withDB calls fn with the open database, opening it for the call if the
+// lock is not held. Must be called with s.mu held.
+func (s *BoltStorage) withDB(fn func(db *bolt.DB) error) error {
+	if s.db != nil {
+		return fn(s.db)
+	}
+	db, err := openBolt(s.Path)
+	if err != nil {
+		return err
+	}
+	err = fn(db)
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Lock opens the database, which bbolt locks exclusively, blocking until
+// no other process has it open.
+func (s *BoltStorage) Lock() (unlock func(), err error) {
+	db, err := openBolt(s.Path)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	s.db = db
+	s.mu.Unlock()
+	return func() {
+		s.mu.Lock()
+		s.db = nil
+		s.mu.Unlock()
+		_ = db.Close()
+	}, nil
+}
+
+// Changed reports whether the database was written since it was last
+// loaded or saved through s.
+func (s *BoltStorage) Changed() (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	changed := true
+	err := s.withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			changed = s.state == nil || tx.ID() != s.txid
+			return nil
+		})
+	})
+	return changed, err
+}
+
+func (s *BoltStorage) Load() (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			if s.state != nil && tx.ID() == s.txid {
+				return nil
+			}
+			if err := s.read(tx); err != nil {
+				s.state = nil
+				return err
+			}
+			s.txid = tx.ID()
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cloneState(s.state, nil), nil
+}
+
+// read caches the queue as stored in tx.
+func (s *BoltStorage) read(tx *bolt.Tx) error {
+	state := &State{Items: []Item{}, Active: false, Mode: ModeQueue}
+	s.state, s.keys = state, nil
+	b := queueBucket(tx, s.queue())
+	if b == nil {
+		return nil
+	}
+	if data := b.Get(stateKey); data != nil {
+		if err := json.Unmarshal(data, state); err != nil {
+			return fmt.Errorf("queue %q: %w", s.queue(), err)
+		}
+		state.Items = []Item{}
+	}
+	items := b.Bucket(itemsBucket)
+	if items == nil {
+		return nil
+	}
+	return items.ForEach(func(k, v []byte) error {
+		var item Item
+		if err := json.Unmarshal(v, &item); err != nil {
+			return fmt.Errorf("queue %q, item %x: %w", s.queue(), k, err)
+		}
+		state.Items = append(state.Items, item)
+		s.keys = append(s.keys, binary.BigEndian.Uint64(k))
+		return nil
+	})
+}
+
+// Save writes the fields that changed and the items added, removed or
+// replaced since the queue was last loaded or saved.
+func (s *BoltStorage) Save(state *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	header, err := marshalHeader(state)
+	if err != nil {
+		return err
+	}
+	var keys []uint64
+	var txid int
+	err = s.withDB(func(db *bolt.DB) error {
+		return db.Update(func(tx *bolt.Tx) error {
+			if s.state == nil || tx.ID() != s.txid+1 {
+				if err := s.read(tx); err != nil {
+					return err
+				}
+			}
+			root, err := tx.CreateBucketIfNotExists(queuesBucket)
+			if err != nil {
+				return err
+			}
+			b, err := root.CreateBucketIfNotExists(s.queue())
+			if err != nil {
+				return err
+			}
+			if err := b.Put(stateKey, header); err != nil {
+				return err
+			}
+			keys, err = writeItems(b, s.state.Items, s.keys, state.Items)
+			txid = tx.ID()
+			return err
+		})
+	})
+	if err != nil {
+		s.state = nil
+		return err
+	}
+	s.state, s.keys, s.txid = cloneState(state, s.state.Items), keys, txid
+	return nil
+}
+
+// writeItems changes the stored items from old, stored under keys, to new
+// and returns the keys of new.
+func writeItems(b *bolt.Bucket, old []Item, keys []uint64, new []Item) ([]uint64, error) {
+	items, err := b.CreateBucketIfNotExists(itemsBucket)
+	if err != nil {
+		return nil, err
+	}
+	created, err := b.CreateBucketIfNotExists(createdBucket)
+	if err != nil {
+		return nil, err
+	}
+	e := diffItems(old, new)
+	if len(e.Ins) > 0 && e.Ins[0].At < len(old)-len(e.Del) {
+		// Inserted before the end; renumber everything.
+		for _, name := range [][]byte{itemsBucket, createdBucket} {
+			if err := b.DeleteBucket(name); err != nil {
+				return nil, err
+			}
+		}
+		return writeItems(b, nil, nil, new)
+	}
+
+	old, keys = slices.Clone(old), slices.Clone(keys)
+	for i := len(e.Del) - 1; i >= 0; i-- {
+		at := e.Del[i]
+		if err := deleteItem(items, created, keys[at], old[at]); err != nil {
+			return nil, err
+		}
+		old, keys = slices.Delete(old, at, at+1), slices.Delete(keys, at, at+1)
+	}
+	for _, put := range e.Put {
+		if err := deleteItem(items, created, keys[put.At], old[put.At]); err != nil {
+			return nil, err
+		}
+		if err := putItem(items, created, keys[put.At], put.Item); err != nil {
+			return nil, err
+		}
+	}
+	for _, in := range e.Ins {
+		key, err := items.NextSequence()
+		if err != nil {
+			return nil, err
+		}
+		if err := putItem(items, created, key, in.Item); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func putItem(items, created *bolt.Bucket, key uint64, item Item) error {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	k := binary.BigEndian.AppendUint64(nil, key)
+	if err := items.Put(k, data); err != nil {
+		return err
+	}
+	if item.Created.IsZero() {
+		return nil
+	}
+	return created.Put(createdKey(item), k)
+}
+
+func deleteItem(items, created *bolt.Bucket, key uint64, item Item) error {
+	if err := items.Delete(binary.BigEndian.AppendUint64(nil, key)); err != nil {
+		return err
+	}
+	if item.Created.IsZero() {
+		return nil
+	}
+	return created.Delete(createdKey(item))
+}
+
+// createdKey orders items by creation time, then ID.
+func createdKey(item Item) []byte {
+	return append(binary.BigEndian.AppendUint64(nil, uint64(item.Created.UnixNano())), item.ID...)
+}
+
+func queueBucket(tx *bolt.Tx, name []byte) *bolt.Bucket {
+	root := tx.Bucket(queuesBucket)
+	if root == nil {
+		return nil
+	}
+	return root.Bucket(name)
+}
+
+// CreatedBetween returns the items of the queue created in [from, to),
+// oldest first, using the index by creation time.
+func (s *BoltStorage) CreatedBetween(from, to time.Time) ([]Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []Item
+	err := s.withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			b := queueBucket(tx, s.queue())
+			if b == nil || b.Bucket(createdBucket) == nil {
+				return nil
+			}
+			items := b.Bucket(itemsBucket)
+			start := binary.BigEndian.AppendUint64(nil, uint64(from.UnixNano()))
+			end := binary.BigEndian.AppendUint64(nil, uint64(to.UnixNano()))
+			c := b.Bucket(createdBucket).Cursor()
+			for k, v := c.Seek(start); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+				var item Item
+				if err := json.Unmarshal(items.Get(v), &item); err != nil {
+					return fmt.Errorf("queue %q, item %x: %w", s.queue(), v, err)
+				}
+				out = append(out, item)
+			}
+			return nil
+		})
+	})
+	return out, err
+}
+
+// Queues returns the names of the queues in the database, sorted.
+func (s *BoltStorage) Queues() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	err := s.withDB(func(db *bolt.DB) error {
+		return db.View(func(tx *bolt.Tx) error {
+			root := tx.Bucket(queuesBucket)
+			if root == nil {
+				return nil
+			}
+			return root.ForEachBucket(func(name []byte) error {
+				names = append(names, string(name))
+				return nil
+			})
+		})
+	})
+	return names, err
+}
+
+// Clear empties the queue, keeping pinned items.
+func (s *BoltStorage) Clear() error {
+	state, err := s.Load()
+	if err != nil {
+		return err
+	}
+	state.Items = slices.Clone(state.Pins)
+	if state.Items == nil {
+		state.Items = []Item{}
+	}
+	return s.Save(state)
+}
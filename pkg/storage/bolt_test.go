@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBoltStorage_SaveAndLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s := NewBoltStorage(path, "")
+
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c, d := NewItem("a"), NewItem("b"), NewItem("c"), NewItem("d")
+	edited := b
+	edited.Text = "B"
+	steps := []struct {
+		name  string
+		items []Item
+	}{
+		{"add", []Item{a}},
+		{"append", []Item{a, b, c}},
+		{"pop head", []Item{b, c}},
+		{"insert", []Item{b, d, c}},
+		{"edit", []Item{edited, d, c}},
+		{"pop tail", []Item{edited, d}},
+		{"empty", []Item{}},
+	}
+	for _, step := range steps {
+		state.Items = step.items
+		state.Active = !state.Active
+		if err := s.Save(state); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		for _, r := range []*BoltStorage{s, NewBoltStorage(path, "")} {
+			got, err := r.Load()
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if !slices.EqualFunc(got.Items, step.items, sameItem) || got.Active != state.Active {
+				t.Errorf("%s: got %+v, want %+v", step.name, got, state)
+			}
+		}
+	}
+}
+
+func TestBoltStorage_Queues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	work, home := NewBoltStorage(path, "work"), NewBoltStorage(path, "")
+	for _, s := range []*BoltStorage{work, home} {
+		if err := s.Save(&State{Items: []Item{NewItem(s.Queue)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, err := work.Load(); err != nil || len(got.Items) != 1 || got.Items[0].Text != "work" {
+		t.Errorf("expected the work queue, got %+v: %v", got, err)
+	}
+	if names, err := home.Queues(); err != nil || !slices.Equal(names, []string{DefaultQueue, "work"}) {
+		t.Errorf("Queues = %v, %v", names, err)
+	}
+}
+
+func TestBoltStorage_CreatedBetween(t *testing.T) {
+	s := NewBoltStorage(filepath.Join(t.TempDir(), "state.db"), "")
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var items []Item
+	for i := range 5 {
+		item := NewItem(string(rune('a' + i)))
+		item.Created = start.Add(time.Duration(i) * time.Hour)
+		items = append(items, item)
+	}
+	// Out of creation order, so the index has to do the sorting.
+	items[1], items[3] = items[3], items[1]
+	if err := s.Save(&State{Items: items}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.CreatedBetween(start.Add(time.Hour), start.Add(3*time.Hour))
+	if err != nil || !slices.Equal(Texts(got), []string{"b", "c"}) {
+		t.Errorf("CreatedBetween = %v, %v", Texts(got), err)
+	}
+
+	// Removed items leave the index.
+	if err := s.Save(&State{Items: items[2:]}); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.CreatedBetween(start, start.Add(24*time.Hour))
+	if err != nil || !slices.Equal(Texts(got), []string{"b", "c", "e"}) {
+		t.Errorf("CreatedBetween = %v, %v", Texts(got), err)
+	}
+}
+
+// CreatedBetween gives the same answer with and without an index, and
+// reads items kept in blobs.
+func TestCreatedBetween(t *testing.T) {
+	dir := t.TempDir()
+	big := strings.Repeat("big ", 100)
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var items []Item
+	for i, text := range []string{"c", big, "a"} {
+		item := NewItem(text)
+		item.Created = start.Add(time.Duration(2-i) * time.Hour)
+		items = append(items, item)
+	}
+	for name, s := range map[string]Storage{
+		"bolt+blobs": NewBlobStorage(NewBoltStorage(filepath.Join(dir, "state.db"), ""), filepath.Join(dir, "blobs"), 100, NoCompression),
+		"memory":     NewMemoryStorage(),
+	} {
+		if err := s.Save(&State{Items: items}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := CreatedBetween(s, start, start.Add(2*time.Hour))
+		if err != nil || !slices.Equal(Texts(got), []string{"a", big}) {
+			t.Errorf("%s: CreatedBetween = %q, %v", name, Texts(got), err)
+		}
+	}
+}
+
+func TestBoltStorage_LockAndChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	s, other := NewBoltStorage(path, ""), NewBoltStorage(path, "")
+	if err := s.Save(&State{Items: []Item{NewItem("a")}}); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Changed(); changed {
+		t.Error("own save reported as a change")
+	}
+
+	unlock, err := s.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := make(chan error)
+	go func() { saved <- other.Save(&State{Items: []Item{NewItem("b")}}) }()
+	select {
+	case err := <-saved:
+		t.Fatalf("save went through while locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	unlock()
+	if err := <-saved; err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Changed(); !changed {
+		t.Error("save by another storage not detected")
+	}
+	if got, err := s.Load(); err != nil || got.Items[0].Text != "b" {
+		t.Errorf("expected the other save, got %+v: %v", got, err)
+	}
+}
+
+func BenchmarkBoltStorage_Save10k(b *testing.B) {
+	benchmarkSave(b, NewBoltStorage(filepath.Join(b.TempDir(), "state.db"), ""), 10000)
+}
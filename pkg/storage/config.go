@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+)
+
+// Backend names a Storage implementation.
+type Backend string
+
+const (
+	BackendJSON    Backend = "json"    // state.json, rewritten on every change
+	BackendJournal Backend = "journal" // state.journal, an append-only log
+	BackendBolt    Backend = "bolt"    // state.db, a bbolt database
+	BackendMemory  Backend = "memory"  // nothing on disk; the queue lives in the monitor
+)
+
+// Backends lists every backend.
+var Backends = []Backend{BackendJSON, BackendJournal, BackendBolt, BackendMemory}
+
+// ParseBackend validates a backend name.
+func ParseBackend(s string) (Backend, error) {
+	for _, b := range Backends {
+		if string(b) == s {
+			return b, nil
+		}
+	}
+	return "", fmt.Errorf("unknown storage %q (want json, journal, bolt or memory)", s)
+}
+
+// Config selects where and how the queue is stored.
+type Config struct {
+	// Storage is the backend; empty means BackendJSON.
+	Storage Backend `json:"storage,omitempty"`
+	// Queue names the queue used within a bolt database; empty means
+	// DefaultQueue.
+	Queue string `json:"queue,omitempty"`
+	// Queues holds settings by queue name.
+	Queues map[string]QueueConfig `json:"queues,omitempty"`
+	// Durability is how the json backend writes the state file; empty
+	// means SyncFile.
+	Durability Durability `json:"durability,omitempty"`
+	// Backups is how many snapshots of the state to keep; zero means
+	// DefaultBackups, a negative number turns them off.
+	Backups int `json:"backups,omitempty"`
+	// BlobThreshold is the size in bytes above which an item's text is kept
+	// in a separate file; zero means DefaultBlobThreshold, a negative number
+	// keeps every item in the state. CompressBlobs is how those files are
+	// compressed.
+	BlobThreshold int         `json:"blob_threshold,omitempty"`
+	CompressBlobs Compression `json:"compress_blobs,omitempty"`
+	// StateFile is where the backend keeps the queue, with its blobs and
+	// backups in StateFile.blobs and StateFile.backups; empty means
+	// state.json, state.journal or state.db in the data directory.
+	StateFile string `json:"state_file,omitempty"`
+}
+
+// QueueConfig holds the settings of a named queue.
+type QueueConfig struct {
+	// Ephemeral keeps the queue in memory only, as BackendMemory does.
+	Ephemeral bool `json:"ephemeral,omitempty"`
+}
+
+// Ephemeral reports whether the queue is kept in memory only, because of
+// BackendMemory or the settings of the queue c selects.
+func (c *Config) Ephemeral() bool {
+	return c.Storage == BackendMemory || c.Queues[cmp.Or(c.Queue, DefaultQueue)].Ephemeral
+}
+
+// LoadConfig reads the config at path; a missing file is the default
+// config.
+func LoadConfig(path string) (*Config, error) {
+	var c Config
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return &c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if c.Storage != "" {
+		if _, err := ParseBackend(string(c.Storage)); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	if c.Durability != "" {
+		if _, err := ParseDurability(string(c.Durability)); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return &c, nil
+}
+
+// SaveConfig writes c to path atomically.
+func SaveConfig(path string, c *Config) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return WriteFileAtomic(path, data)
+}
+
+// Open returns the storage c selects, with its files in dir unless
+// StateFile is set.
+func (c *Config) Open(dir string) Storage {
+	if c.Ephemeral() {
+		return NewMemoryStorage()
+	}
+	var s Storage
+	switch c.Storage {
+	case BackendJournal:
+		s = NewJournalStorage(c.statePath(dir, "state.journal"))
+	case BackendBolt:
+		s = NewBoltStorage(c.statePath(dir, "state.db"), c.Queue)
+	default:
+		js := NewJSONStorage(c.statePath(dir, "state.json"))
+		js.Durability = c.Durability
+		s = js
+	}
+	if c.BlobThreshold < 0 {
+		return s
+	}
+	blobs := c.blobStorage(s, dir)
+	if snapshots := c.Snapshots(dir); snapshots != nil {
+		blobs.SnapshotDir = snapshots.Dir
+	}
+	return blobs
+}
+
+// blobStorage returns a BlobStorage around s with the threshold c sets, or
+// with none, so that no new blobs are written, if blobs are off.
+func (c *Config) blobStorage(s Storage, dir string) *BlobStorage {
+	threshold := c.BlobThreshold
+	switch {
+	case threshold < 0:
+		threshold = math.MaxInt
+	case threshold == 0:
+		threshold = DefaultBlobThreshold
+	}
+	return NewBlobStorage(s, c.sidePath(dir, "blobs"), threshold, c.CompressBlobs)
+}
+
+// statePath returns StateFile, if set, or the file name in dir.
+func (c *Config) statePath(dir, name string) string {
+	if c.StateFile != "" {
+		return c.StateFile
+	}
+	return filepath.Join(dir, name)
+}
+
+// sidePath returns the directory name in dir, or StateFile.name if
+// StateFile is set, so queues in different state files never share blobs
+// or backups.
+func (c *Config) sidePath(dir, name string) string {
+	if c.StateFile != "" {
+		return c.StateFile + "." + name
+	}
+	return filepath.Join(dir, name)
+}
+
+// Snapshots returns the snapshots c keeps in dir, or nil if they are off,
+// as they always are for an ephemeral queue. They keep large items in the
+// same blobs as the storage Open returns.
+func (c *Config) Snapshots(dir string) *Snapshots {
+	keep := c.Backups
+	switch {
+	case keep < 0 || c.Ephemeral():
+		return nil
+	case keep == 0:
+		keep = DefaultBackups
+	}
+	snapshots := NewSnapshots(c.sidePath(dir, "backups"), keep)
+	snapshots.Blobs = c.blobStorage(nil, dir)
+	return snapshots
+}
+
+// Migrate copies the state stored in from to to, holding the locks of
+// both, and returns it. from is left as it is.
+func Migrate(from, to Storage) (*State, error) {
+	for _, s := range []Storage{from, to} {
+		if l, ok := s.(Locker); ok {
+			unlock, err := l.Lock()
+			if err != nil {
+				return nil, err
+			}
+			defer unlock()
+		}
+	}
+	state, err := from.Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := to.Save(state); err != nil {
+		return nil, err
+	}
+	return state, nil
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Unwrap(cfg.Open(dir)).(*JSONStorage); !ok {
+		t.Errorf("expected JSON storage by default, got %T", Unwrap(cfg.Open(dir)))
+	}
+	if _, ok := cfg.Open(dir).(*BlobStorage); !ok {
+		t.Errorf("expected large items in blobs by default, got %T", cfg.Open(dir))
+	}
+
+	if err := SaveConfig(path, &Config{Storage: BackendBolt, Queue: "work", BlobThreshold: -1}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, ok := cfg.Open(dir).(*BoltStorage); !ok || s.Queue != "work" {
+		t.Errorf("expected the work queue in a bolt database, got %+v", cfg.Open(dir))
+	}
+
+	os.WriteFile(path, []byte(`{"storage": "floppy"}`), 0644)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+
+	// compress_blobs takes a compression, or true for gzip as it used to.
+	for data, want := range map[string]Compression{`true`: Gzip, `false`: NoCompression, `"zstd"`: Zstd} {
+		os.WriteFile(path, []byte(`{"compress_blobs": `+data+`}`), 0644)
+		if cfg, err := LoadConfig(path); err != nil || cfg.Open(dir).(*BlobStorage).Compress != want {
+			t.Errorf("compress_blobs %s: got %+v, %v; want %q", data, cfg, err, want)
+		}
+	}
+	os.WriteFile(path, []byte(`{"compress_blobs": "rar"}`), 0644)
+	if _, err := LoadConfig(path); err == nil {
+		t.Error("expected an error for an unknown compression")
+	}
+}
+
+func TestConfig_StateFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(t.TempDir(), "work.json")
+	cfg := &Config{StateFile: file}
+	s := cfg.Open(dir).(*BlobStorage)
+	if js := s.Base.(*JSONStorage); js.Path != file {
+		t.Errorf("expected the queue in %s, got %s", file, js.Path)
+	}
+	if s.Dir != file+".blobs" {
+		t.Errorf("expected blobs next to the state file, got %s", s.Dir)
+	}
+	if snaps := cfg.Snapshots(dir); snaps.Dir != file+".backups" {
+		t.Errorf("expected backups next to the state file, got %s", snaps.Dir)
+	}
+}
+
+func TestConfig_MemoryWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{Storage: BackendMemory}
+	s := cfg.Open(dir)
+	if _, ok := s.(*MemoryStorage); !ok {
+		t.Fatalf("expected memory storage, got %T", s)
+	}
+	if cfg.Snapshots(dir) != nil {
+		t.Error("expected no snapshots for memory storage")
+	}
+	big := NewItem(strings.Repeat("x", 2*DefaultBlobThreshold))
+	if err := s.Save(&State{Items: []Item{big}, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("expected nothing written to %s, found %d entries", dir, len(entries))
+	}
+}
+
+func TestConfig_EphemeralQueue(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{Storage: BackendBolt, Queue: "scratch", Queues: map[string]QueueConfig{"scratch": {Ephemeral: true}}}
+	if !cfg.Ephemeral() {
+		t.Fatal("expected the scratch queue to be ephemeral")
+	}
+	if s, ok := cfg.Open(dir).(*MemoryStorage); !ok {
+		t.Fatalf("expected memory storage, got %T", s)
+	}
+	if cfg.Snapshots(dir) != nil {
+		t.Error("expected no snapshots for an ephemeral queue")
+	}
+
+	cfg.Queue = ""
+	if cfg.Ephemeral() {
+		t.Error("expected the default queue to be kept on disk")
+	}
+	cfg.Queues = map[string]QueueConfig{DefaultQueue: {Ephemeral: true}}
+	if !cfg.Ephemeral() {
+		t.Error("expected settings for the default queue to apply when none is selected")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	from := NewJSONStorage(filepath.Join(dir, "state.json"))
+	want := &State{Items: []Item{NewItem("a"), NewItem("b")}, Active: true, Mode: ModeStack, Template: "{{.Text}}!"}
+	if err := from.Save(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// Through every backend and back.
+	var s Storage = from
+	for _, backend := range []Backend{BackendBolt, BackendJournal, BackendJSON} {
+		to := (&Config{Storage: backend}).Open(filepath.Join(dir, string(backend)))
+		if _, err := Migrate(s, to); err != nil {
+			t.Fatalf("to %s: %v", backend, err)
+		}
+		s = to
+	}
+	got, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Items) != 2 || got.Items[1].ID != want.Items[1].ID || !got.Active ||
+		got.Mode != ModeStack || got.Template != want.Template {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
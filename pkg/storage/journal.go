@@ -0,0 +1,362 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+)
+
+// defaultCompactAfter is how many bytes of entries JournalStorage appends
+// before compacting, unless the snapshot is larger.
+const defaultCompactAfter = 64 << 10
+
+// JournalStorage stores state as an append-only log of changes, so a save
+// costs as much as the change rather than the whole queue. The file holds
+// one JSON entry per line: a snapshot of the whole state, followed by the
+// items deleted, inserted and replaced and the other fields changed by each
+// save. Once the entries outgrow the snapshot (and CompactAfter), the log is
+// compacted into a single snapshot again, so a save costs amortized O(change)
+// on disk, though still O(n) in memory to find the change.
+//
+// Every save appends a single line, so a crash can at worst leave a partial
+// last line. Replay ignores it, and the next save compacts the log.
+type JournalStorage struct {
+	Path string
+	// CompactAfter is the minimum size in bytes of the entries after the
+	// snapshot before compacting; zero means defaultCompactAfter.
+	CompactAfter int64
+
+	mu       sync.Mutex
+	state    *State      // the state as of offset, nil if unknown
+	header   []byte      // state without its items, as JSON
+	snapshot int64       // size of the snapshot entry
+	offset   int64       // end of the last complete entry
+	entries  int         // entries up to offset
+	seen     os.FileInfo // the journal as last loaded or saved
+}
+
+func NewJournalStorage(path string) *JournalStorage {
+	return &JournalStorage{Path: path}
+}
+
+// journalEntry is one line of the journal. Del holds indexes into the items
+// before the entry, in ascending order; Ins and Put indexes into the items
+// after it.
+type journalEntry struct {
+	Snapshot *State          `json:"snapshot,omitempty"`
+	State    json.RawMessage `json:"state,omitempty"`
+	Del      []int           `json:"del,omitempty"`
+	Ins      []journalItem   `json:"ins,omitempty"`
+	Put      []journalItem   `json:"put,omitempty"`
+}
+
+type journalItem struct {
+	At   int  `json:"at"`
+	Item Item `json:"item"`
+}
+
+// Load replays the journal. If the journal was only appended to since it
+// was last loaded or saved, just the new entries are read.
+func (s *JournalStorage) Load() (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		s.forget()
+		return &State{Items: []Item{}, Active: false, Mode: ModeQueue}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if s.state == nil || s.seen == nil || !os.SameFile(info, s.seen) || info.Size() < s.offset {
+		s.forget()
+	} else if _, err := f.Seek(s.offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.replay(data); err != nil {
+		s.forget()
+		return nil, fmt.Errorf("%s: %w", s.Path, err)
+	}
+	s.seen = info
+	if s.state == nil {
+		// An empty journal; the next save starts it with a snapshot.
+		return &State{Items: []Item{}, Active: false, Mode: ModeQueue}, nil
+	}
+	return cloneState(s.state, nil), nil
+}
+
+// replay applies every complete entry in data, which starts at s.offset.
+func (s *JournalStorage) replay(data []byte) error {
+	for {
+		line, rest, ok := bytes.Cut(data, []byte("\n"))
+		if !ok {
+			return nil // nothing left, or a partial entry from a crash
+		}
+		var e journalEntry
+		if err := json.Unmarshal(line, &e); err != nil {
+			return fmt.Errorf("journal entry %d: %w", s.entries+1, err)
+		}
+		if err := s.apply(&e); err != nil {
+			return fmt.Errorf("journal entry %d: %w", s.entries+1, err)
+		}
+		s.offset += int64(len(line) + 1)
+		s.entries++
+		if e.Snapshot != nil {
+			s.snapshot = int64(len(line) + 1)
+		}
+		data = rest
+	}
+}
+
+func (s *JournalStorage) apply(e *journalEntry) error {
+	if e.Snapshot != nil {
+		if e.Snapshot.Items == nil {
+			e.Snapshot.Items = []Item{}
+		}
+		header, err := marshalHeader(e.Snapshot)
+		if err != nil {
+			return err
+		}
+		s.state, s.header = e.Snapshot, header
+		return nil
+	}
+	if s.state == nil {
+		return errors.New("no snapshot to apply changes to")
+	}
+	items := s.state.Items
+	for i := len(e.Del) - 1; i >= 0; i-- {
+		at := e.Del[i]
+		if at < 0 || at >= len(items) {
+			return fmt.Errorf("deleted index %d out of range", at)
+		}
+		items = slices.Delete(items, at, at+1)
+	}
+	for _, in := range e.Ins {
+		if in.At < 0 || in.At > len(items) {
+			return fmt.Errorf("inserted index %d out of range", in.At)
+		}
+		items = slices.Insert(items, in.At, in.Item)
+	}
+	for _, put := range e.Put {
+		if put.At < 0 || put.At >= len(items) {
+			return fmt.Errorf("replaced index %d out of range", put.At)
+		}
+		items[put.At] = put.Item
+	}
+	if e.State != nil {
+		var state State
+		if err := json.Unmarshal(e.State, &state); err != nil {
+			return err
+		}
+		*s.state = state
+		s.header = e.State
+	}
+	s.state.Items = items
+	return nil
+}
+
+// Save appends the changes since the journal was last loaded or saved. It
+// compacts instead if the journal is due for it or was changed by someone
+// else.
+func (s *JournalStorage) Save(state *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	header, err := marshalHeader(state)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(s.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	compactAfter := s.CompactAfter
+	if compactAfter <= 0 {
+		compactAfter = defaultCompactAfter
+	}
+	if s.state == nil || info == nil || s.seen == nil || !os.SameFile(info, s.seen) ||
+		info.Size() != s.offset || s.offset-s.snapshot > max(compactAfter, s.snapshot) {
+		return s.compact(state, header)
+	}
+	e := diffItems(s.state.Items, state.Items)
+	if !bytes.Equal(header, s.header) {
+		e.State = header
+	}
+	if e.State == nil && len(e.Del)+len(e.Ins)+len(e.Put) == 0 {
+		return nil
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	f, err := os.OpenFile(s.Path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(line)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// The entry may be partly written; have the next save compact.
+		s.forget()
+		return err
+	}
+	s.state, s.header = cloneState(state, s.state.Items), header
+	s.offset += int64(len(line))
+	s.entries++
+	return nil
+}
+
+// compact replaces the journal with a snapshot of state.
+func (s *JournalStorage) compact(state *State, header []byte) error {
+	line, err := json.Marshal(journalEntry{Snapshot: state})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if err := WriteFileAtomic(s.Path, line); err != nil {
+		s.forget()
+		return err
+	}
+	info, err := os.Stat(s.Path)
+	if err != nil {
+		s.forget()
+		return err
+	}
+	s.state, s.header = cloneState(state, nil), header
+	s.snapshot, s.offset, s.entries, s.seen = int64(len(line)), int64(len(line)), 1, info
+	return nil
+}
+
+func (s *JournalStorage) forget() {
+	s.state, s.header = nil, nil
+	s.snapshot, s.offset, s.entries, s.seen = 0, 0, 0, nil
+}
+
+// Changed reports whether the journal was replaced, appended to or removed
+// since it was last loaded or saved through s.
+func (s *JournalStorage) Changed() (bool, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if info == nil || s.seen == nil {
+		return info != s.seen, nil
+	}
+	return !os.SameFile(info, s.seen) || info.Size() != s.offset, nil
+}
+
+// Lock takes an exclusive lock shared by every process using the same
+// journal, blocking until it is available.
+func (s *JournalStorage) Lock() (unlock func(), err error) {
+	return lockFile(s.Path + ".lock")
+}
+
+// Clear empties the queue, keeping pinned items.
+func (s *JournalStorage) Clear() error {
+	state, err := s.Load()
+	if err != nil {
+		return err
+	}
+	state.Items = slices.Clone(state.Pins)
+	if state.Items == nil {
+		state.Items = []Item{}
+	}
+	return s.Save(state)
+}
+
+// diffItems describes how to turn old into new as a journal entry.
+func diffItems(old, new []Item) journalEntry {
+	var e journalEntry
+	i, j := 0, 0
+	for i < len(old) && j < len(new) {
+		if old[i].ID == new[j].ID {
+			if !sameItem(old[i], new[j]) {
+				e.Put = append(e.Put, journalItem{At: j, Item: new[j]})
+			}
+			i, j = i+1, j+1
+			continue
+		}
+		// Saves mostly add or remove a few items, so look a little ahead for
+		// where the two line up again.
+		if d := indexID(old[i+1:min(i+1+diffLookahead, len(old))], new[j].ID); d >= 0 {
+			for range d + 1 {
+				e.Del = append(e.Del, i)
+				i++
+			}
+		} else if d := indexID(new[j+1:min(j+1+diffLookahead, len(new))], old[i].ID); d >= 0 {
+			for range d + 1 {
+				e.Ins = append(e.Ins, journalItem{At: j, Item: new[j]})
+				j++
+			}
+		} else {
+			e.Put = append(e.Put, journalItem{At: j, Item: new[j]})
+			i, j = i+1, j+1
+		}
+	}
+	for ; i < len(old); i++ {
+		e.Del = append(e.Del, i)
+	}
+	for ; j < len(new); j++ {
+		e.Ins = append(e.Ins, journalItem{At: j, Item: new[j]})
+	}
+	return e
+}
+
+// diffLookahead is how far diffItems looks for a matching item.
+const diffLookahead = 16
+
+func indexID(items []Item, id string) int {
+	return slices.IndexFunc(items, func(it Item) bool { return it.ID == id })
+}
+
+func sameItem(a, b Item) bool {
+	return a.ID == b.ID && a.Text == b.Text && a.Created.Equal(b.Created) &&
+		a.Priority == b.Priority && a.Pinned == b.Pinned && a.Repeat == b.Repeat &&
+		maps.Equal(a.Meta, b.Meta) && a.Blob == b.Blob
+}
+
+// marshalHeader returns state without its items as JSON.
+func marshalHeader(state *State) ([]byte, error) {
+	header := *state
+	header.Items = nil
+	return json.Marshal(&header)
+}
+
+// cloneState copies state deeply enough that changes to either copy, short
+// of modifying an item's Meta map in place, do not affect the other. The
+// items are copied into buf if it is large enough.
+func cloneState(state *State, buf []Item) *State {
+	c := *state
+	c.Items = append(buf[:0], state.Items...)
+	if c.Items == nil {
+		c.Items = []Item{}
+	}
+	c.Pins = slices.Clone(state.Pins)
+	c.History = slices.Clone(state.History)
+	c.Modes = slices.Clone(state.Modes)
+	c.CaptureTransforms = slices.Clone(state.CaptureTransforms)
+	c.PasteTransforms = slices.Clone(state.PasteTransforms)
+	return &c
+}
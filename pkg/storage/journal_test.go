@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestJournalStorage_Replay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.journal")
+	s := NewJournalStorage(path)
+
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b, c, d := NewItem("a"), NewItem("b"), NewItem("c"), NewItem("d")
+	edited := b
+	edited.Text = "B"
+	edited.Meta = map[string]string{"k": "v"}
+	steps := []struct {
+		name  string
+		items []Item
+	}{
+		{"add", []Item{a}},
+		{"append", []Item{a, b, c}},
+		{"pop head", []Item{b, c}},
+		{"insert", []Item{b, d, c}},
+		{"edit", []Item{edited, d, c}},
+		{"pop tail", []Item{edited, d}},
+		{"reorder", []Item{d, edited}},
+		{"empty", []Item{}},
+	}
+	for _, step := range steps {
+		state.Items = step.items
+		state.Active = !state.Active
+		if err := s.Save(state); err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		// A second storage replays the journal from scratch, the first only
+		// what it has not seen yet.
+		for _, r := range []*JournalStorage{s, NewJournalStorage(path)} {
+			got, err := r.Load()
+			if err != nil {
+				t.Fatalf("%s: %v", step.name, err)
+			}
+			if !slices.EqualFunc(got.Items, step.items, sameItem) || got.Active != state.Active {
+				t.Errorf("%s: got %+v, want %+v", step.name, got, state)
+			}
+		}
+	}
+
+	// Saving an unchanged state writes nothing.
+	before, _ := os.ReadFile(path)
+	if err := s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(path); len(after) != len(before) {
+		t.Error("unchanged state was written")
+	}
+	// A snapshot from the first save, then an entry for each other one.
+	if lines := strings.Count(string(before), "\n"); lines != len(steps) {
+		t.Errorf("expected %d lines, got %d", len(steps), lines)
+	}
+}
+
+func TestJournalStorage_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.journal")
+	s := NewJournalStorage(path)
+	s.CompactAfter = 1000
+
+	state, _ := s.Load()
+	for i := range 100 {
+		state.Items = append(state.Items, NewItem(fmt.Sprint(i)))
+		if len(state.Items) > 3 {
+			state.Items = state.Items[1:]
+		}
+		if err := s.Save(state); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if info, _ := os.Stat(path); info.Size() > 2000 {
+		t.Errorf("expected the journal compacted, got %d bytes", info.Size())
+	}
+	got, err := NewJournalStorage(path).Load()
+	if err != nil || len(got.Items) != 3 || got.Items[2].Text != "99" {
+		t.Errorf("got %+v: %v", got, err)
+	}
+}
+
+func TestJournalStorage_PartialEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.journal")
+	s := NewJournalStorage(path)
+	state, _ := s.Load()
+	state.Items = []Item{NewItem("a")}
+	if err := s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+
+	// A crash in the middle of appending an entry.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"ins":[{"at":1,"item":{"id":"x","te`)
+	f.Close()
+
+	r := NewJournalStorage(path)
+	got, err := r.Load()
+	if err != nil || len(got.Items) != 1 {
+		t.Fatalf("expected the partial entry ignored, got %+v: %v", got, err)
+	}
+	got.Items = append(got.Items, NewItem("b"))
+	if err := r.Save(got); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := NewJournalStorage(path).Load(); err != nil || len(got.Items) != 2 {
+		t.Errorf("expected the save after a crash to be readable, got %+v: %v", got, err)
+	}
+
+	// A damaged complete entry is an error rather than silently lost items.
+	os.WriteFile(path, []byte("{\"snapshot\":{\"items\":[]}}\nnot json\n"), 0644)
+	if _, err := NewJournalStorage(path).Load(); err == nil {
+		t.Error("expected an error for a damaged entry")
+	}
+}
+
+func TestJournalStorage_Changed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.journal")
+	s, other := NewJournalStorage(path), NewJournalStorage(path)
+
+	state, _ := s.Load()
+	state.Items = []Item{NewItem("a")}
+	if err := s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Changed(); changed {
+		t.Error("own save reported as a change")
+	}
+
+	theirs, _ := other.Load()
+	theirs.Items = append(theirs.Items, NewItem("b"))
+	if err := other.Save(theirs); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Changed(); !changed {
+		t.Error("entry appended by another writer not detected")
+	}
+	if got, err := s.Load(); err != nil || len(got.Items) != 2 {
+		t.Errorf("expected the appended item, got %+v: %v", got, err)
+	}
+	if changed, _ := s.Changed(); changed {
+		t.Error("change still reported after loading")
+	}
+}
+
+func TestDiffItems(t *testing.T) {
+	a, b, c := NewItem("a"), NewItem("b"), NewItem("c")
+	e := diffItems([]Item{a, b}, []Item{b, c})
+	if !slices.Equal(e.Del, []int{0}) || len(e.Ins) != 1 || e.Ins[0].At != 1 || len(e.Put) != 0 {
+		t.Errorf("got %+v", e)
+	}
+
+	// Items that cannot be lined up are replaced.
+	e = diffItems([]Item{a}, []Item{b, b})
+	if len(e.Del) != 0 || len(e.Ins) != 1 || len(e.Put) != 1 || e.Put[0].At != 0 {
+		t.Errorf("got %+v", e)
+	}
+}
+
+// benchmarkSave pastes and captures one item per save, on a queue of n.
+func benchmarkSave(b *testing.B, s Storage, n int) {
+	state, err := s.Load()
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := range n {
+		state.Items = append(state.Items, NewItem(fmt.Sprintf("item %d %s", i, strings.Repeat("x", 64))))
+	}
+	if err := s.Save(state); err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; b.Loop(); i++ {
+		state.Items = append(state.Items[1:], NewItem(fmt.Sprint("new ", i)))
+		if err := s.Save(state); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkJSONStorage_Save10k(b *testing.B) {
+	benchmarkSave(b, NewJSONStorage(filepath.Join(b.TempDir(), "state.json")), 10000)
+}
+
+func BenchmarkJournalStorage_Save10k(b *testing.B) {
+	benchmarkSave(b, NewJournalStorage(filepath.Join(b.TempDir(), "state.journal")), 10000)
+}
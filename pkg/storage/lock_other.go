@@ -0,0 +1,9 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package storage
+
+// lockFile is a no-op on platforms without flock; access is then only
+// serialized within a process.
+func lockFile(path string) (unlock func(), err error) {
+	return func() {}, nil
+}
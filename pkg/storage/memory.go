@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"maps"
+	"slices"
+	"sync"
+)
+
+// MemoryStorage keeps the state in memory only: nothing is written to disk
+// and the queue is gone when the process exits. Load and Save copy the
+// state, so callers never share items with it. It is safe for concurrent
+// use.
+type MemoryStorage struct {
+	mu    sync.Mutex
+	state *State // nil until the first save
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return &State{Items: []Item{}, Active: false, Mode: ModeQueue}, nil
+	}
+	return copyState(s.state), nil
+}
+
+func (s *MemoryStorage) Save(state *State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = copyState(state)
+	return nil
+}
+
+// Clear empties the queue, keeping pinned items.
+func (s *MemoryStorage) Clear() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.state == nil {
+		return nil
+	}
+	s.state.Items = copyItems(s.state.Pins)
+	if s.state.Items == nil {
+		s.state.Items = []Item{}
+	}
+	return nil
+}
+
+// copyState returns a copy of state sharing nothing with it, unlike
+// cloneState, which shares the items' Meta.
+func copyState(state *State) *State {
+	c := cloneState(state, nil)
+	c.Items = copyItems(c.Items)
+	c.Pins = copyItems(c.Pins)
+	c.History = copyItems(c.History)
+	return c
+}
+
+func copyItems(items []Item) []Item {
+	items = slices.Clone(items)
+	for i := range items {
+		items[i].Meta = maps.Clone(items[i].Meta)
+	}
+	return items
+}
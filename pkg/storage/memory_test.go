@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestMemoryStorage_Copies(t *testing.T) {
+	s := NewMemoryStorage()
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Items) != 0 || state.Items == nil || state.Active {
+		t.Fatalf("expected an empty, stopped queue, got %+v", state)
+	}
+
+	item := NewItem("a")
+	item.Meta = map[string]string{"k": "v"}
+	state.Items = append(state.Items, item)
+	if err := s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	state.Items[0].Text = "changed"
+	state.Items[0].Meta["k"] = "changed"
+
+	got, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Items[0].Text != "a" || got.Items[0].Meta["k"] != "v" {
+		t.Errorf("saved state changed with the caller's copy: %+v", got.Items[0])
+	}
+	got.Items[0].Meta["k"] = "changed"
+	if again, _ := s.Load(); again.Items[0].Meta["k"] != "v" {
+		t.Errorf("stored state changed with a loaded copy: %+v", again.Items[0])
+	}
+}
+
+func TestMemoryStorage_ClearKeepsPins(t *testing.T) {
+	s := NewMemoryStorage()
+	pin := NewItem("pinned")
+	pin.Pinned = true
+	s.Save(&State{Items: []Item{NewItem("a"), pin}, Pins: []Item{pin}, Active: true})
+	if err := s.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := s.Load()
+	if len(got.Items) != 1 || got.Items[0].ID != pin.ID || !got.Active {
+		t.Errorf("expected only the pinned item left, got %+v", got)
+	}
+}
+
+func TestMemoryStorage_Concurrent(t *testing.T) {
+	s := NewMemoryStorage()
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				state, err := s.Load()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				state.Items = append(state.Items, NewItem("x"))
+				s.Save(state)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := s.Load(); len(got.Items) == 0 {
+		t.Error("expected items after concurrent saves")
+	}
+}
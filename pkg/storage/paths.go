@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// HomeEnv names the environment variable that, if set, is the one
+// directory cbq keeps all its files in.
+const HomeEnv = "CBQ_HOME"
+
+// movedNote is left in the old directory once its files were moved.
+const movedNote = "MOVED.txt"
+
+// Dirs are the directories cbq keeps its files in.
+type Dirs struct {
+	Data   string // the queue, its blobs and backups, and the snippets
+	Config string // config.json
+	State  string // the log
+}
+
+// DefaultDirs returns the directories cbq keeps its files in: $CBQ_HOME if
+// set; on Linux the XDG base directories, by default ~/.local/share/cbq,
+// ~/.config/cbq and ~/.local/state/cbq; otherwise ~/.cbq.
+func DefaultDirs() (Dirs, error) {
+	home, err := os.UserHomeDir()
+	if err != nil && os.Getenv(HomeEnv) == "" {
+		return Dirs{}, err
+	}
+	return dirsFor(runtime.GOOS, home, os.Getenv), nil
+}
+
+func dirsFor(goos, home string, getenv func(string) string) Dirs {
+	if dir := getenv(HomeEnv); dir != "" {
+		return Dirs{Data: dir, Config: dir, State: dir}
+	}
+	if goos != "linux" {
+		dir := filepath.Join(home, ".cbq")
+		return Dirs{Data: dir, Config: dir, State: dir}
+	}
+	xdg := func(env, fallback string) string {
+		// Relative paths are invalid per the spec and must be ignored.
+		if dir := getenv(env); filepath.IsAbs(dir) {
+			return filepath.Join(dir, "cbq")
+		}
+		return filepath.Join(home, fallback, "cbq")
+	}
+	return Dirs{
+		Data:   xdg("XDG_DATA_HOME", ".local/share"),
+		Config: xdg("XDG_CONFIG_HOME", ".config"),
+		State:  xdg("XDG_STATE_HOME", ".local/state"),
+	}
+}
+
+// DefaultDir returns the directory cbq keeps the queue in.
+func DefaultDir() (string, error) {
+	dirs, err := DefaultDirs()
+	return dirs.Data, err
+}
+
+// DefaultConfigPath returns config.json in the config directory.
+func DefaultConfigPath() (string, error) {
+	dirs, err := DefaultDirs()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirs.Config, "config.json"), nil
+}
+
+// DefaultLogPath returns cbq.log in the state directory.
+func DefaultLogPath() (string, error) {
+	dirs, err := DefaultDirs()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dirs.State, "cbq.log"), nil
+}
+
+// MoveLegacyFiles moves the files older versions kept in ~/.cbq to the
+// default directories, if those are elsewhere, and returns the old
+// directory and the names of the files moved. Nothing is done when
+// CBQ_HOME is set, not even looking up the home directory. See
+// moveLegacyFiles.
+func MoveLegacyFiles() (legacy string, moved []string, err error) {
+	if os.Getenv(HomeEnv) != "" {
+		return "", nil, nil
+	}
+	dirs, err := DefaultDirs()
+	if err != nil {
+		return "", nil, err
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", nil, err
+	}
+	legacy = filepath.Join(home, ".cbq")
+	if slices.Contains([]string{dirs.Data, dirs.Config, dirs.State}, legacy) {
+		return legacy, nil, nil
+	}
+	moved, err = moveLegacyFiles(legacy, dirs)
+	return legacy, moved, err
+}
+
+// moveLegacyFiles moves config.json from legacy to the config directory,
+// cbq.log to the state directory and everything else to the data
+// directory, and then removes legacy. Files that exist in their new place
+// already, and lock files that a running monitor of an older version may
+// hold, are left behind together with a note that stops them from being
+// moved later. Nothing is done if legacy does not exist or has the note.
+func moveLegacyFiles(legacy string, dirs Dirs) ([]string, error) {
+	entries, err := os.ReadDir(legacy)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(filepath.Join(legacy, movedNote)); err == nil {
+		return nil, nil
+	}
+
+	var moved, left []string
+	for _, e := range entries {
+		name := e.Name()
+		dir := dirs.Data
+		switch {
+		case name == "config.json":
+			dir = dirs.Config
+		case name == "cbq.log":
+			dir = dirs.State
+		case strings.HasSuffix(name, ".lock"):
+			left = append(left, name)
+			continue
+		}
+		to := filepath.Join(dir, name)
+		if _, err := os.Lstat(to); err == nil {
+			left = append(left, name)
+			continue
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return moved, err
+		}
+		if err := os.Rename(filepath.Join(legacy, name), to); err != nil {
+			return moved, fmt.Errorf("moving %s to %s: %w", name, dir, err)
+		}
+		moved = append(moved, name)
+	}
+
+	if err := os.Remove(legacy); err == nil || errors.Is(err, os.ErrNotExist) {
+		return moved, nil
+	}
+	note := fmt.Sprintf("cbq now keeps its files in\n  %s (data)\n  %s (config)\n  %s (log)\n\nThese were left here: %s\n",
+		dirs.Data, dirs.Config, dirs.State, strings.Join(left, ", "))
+	return moved, WriteFileAtomic(filepath.Join(legacy, movedNote), []byte(note))
+}
@@ -0,0 +1,98 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDirsFor(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(key string) string { return vars[key] }
+	}
+	tests := []struct {
+		name string
+		goos string
+		env  map[string]string
+		want Dirs
+	}{
+		{"mac", "darwin", nil, Dirs{"/h/.cbq", "/h/.cbq", "/h/.cbq"}},
+		{"linux defaults", "linux", nil,
+			Dirs{"/h/.local/share/cbq", "/h/.config/cbq", "/h/.local/state/cbq"}},
+		{"linux xdg", "linux", map[string]string{"XDG_DATA_HOME": "/d", "XDG_CONFIG_HOME": "/c", "XDG_STATE_HOME": "relative"},
+			Dirs{"/d/cbq", "/c/cbq", "/h/.local/state/cbq"}},
+		{"override", "linux", map[string]string{HomeEnv: "/x", "XDG_DATA_HOME": "/d"}, Dirs{"/x", "/x", "/x"}},
+	}
+	for _, tt := range tests {
+		if got := dirsFor(tt.goos, "/h", env(tt.env)); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMoveLegacyFiles(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, ".cbq")
+	dirs := Dirs{Data: filepath.Join(root, "data"), Config: filepath.Join(root, "config"), State: filepath.Join(root, "state")}
+	for _, name := range []string{"state.json", "config.json", "cbq.log", "state.json.lock", "blobs/abc"} {
+		os.MkdirAll(filepath.Dir(filepath.Join(legacy, name)), 0755)
+		os.WriteFile(filepath.Join(legacy, name), []byte(name), 0644)
+	}
+
+	moved, err := moveLegacyFiles(legacy, dirs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(moved) != 4 {
+		t.Errorf("expected 4 files moved, got %v", moved)
+	}
+	for _, path := range []string{
+		filepath.Join(dirs.Data, "state.json"),
+		filepath.Join(dirs.Data, "blobs", "abc"),
+		filepath.Join(dirs.Config, "config.json"),
+		filepath.Join(dirs.State, "cbq.log"),
+		filepath.Join(legacy, "state.json.lock"),
+	} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("expected %s: %v", path, err)
+		}
+	}
+	note, err := os.ReadFile(filepath.Join(legacy, movedNote))
+	if err != nil || !strings.Contains(string(note), "state.json.lock") {
+		t.Errorf("expected a note listing the lock file, got %q, %v", note, err)
+	}
+
+	// Only once.
+	os.WriteFile(filepath.Join(legacy, "snippets.json"), nil, 0644)
+	if moved, err := moveLegacyFiles(legacy, dirs); err != nil || len(moved) != 0 {
+		t.Errorf("expected nothing moved again, got %v, %v", moved, err)
+	}
+}
+
+func TestMoveLegacyFiles_RemovesEmptyDir(t *testing.T) {
+	root := t.TempDir()
+	legacy := filepath.Join(root, ".cbq")
+	os.MkdirAll(legacy, 0755)
+	os.WriteFile(filepath.Join(legacy, "state.json"), []byte("{}"), 0644)
+	dirs := Dirs{Data: filepath.Join(root, "data"), Config: filepath.Join(root, "config"), State: filepath.Join(root, "state")}
+
+	if _, err := moveLegacyFiles(legacy, dirs); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(legacy); !os.IsNotExist(err) {
+		t.Errorf("expected %s removed, got %v", legacy, err)
+	}
+	if moved, err := moveLegacyFiles(legacy, dirs); err != nil || moved != nil {
+		t.Errorf("expected nothing to do, got %v, %v", moved, err)
+	}
+}
+
+func TestMoveLegacyFiles_HomeEnv(t *testing.T) {
+	t.Setenv(HomeEnv, t.TempDir())
+	t.Setenv("HOME", "")
+	legacy, moved, err := MoveLegacyFiles()
+	if err != nil || legacy != "" || moved != nil {
+		t.Errorf("expected nothing to do without a home directory, got %q, %v, %v", legacy, moved, err)
+	}
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Recovery describes how Load dealt with a state file it could not parse.
+type Recovery struct {
+	Err         error  // why the file could not be parsed
+	Quarantined string // where the damaged file was moved
+	FromBackup  bool   // whether the state was restored from the backup
+	Items       int    // how many items were recovered
+}
+
+func (r Recovery) String() string {
+	if r.FromBackup {
+		return fmt.Sprintf("the queue was damaged (%v) and was restored from its backup with %d items; the damaged file was moved to %s",
+			r.Err, r.Items, r.Quarantined)
+	}
+	return fmt.Sprintf("the queue was damaged (%v); %d items could be recovered into a new, stopped queue and the damaged file was moved to %s",
+		r.Err, r.Items, r.Quarantined)
+}
+
+// recoverFile moves a state file that cannot be parsed aside, with a
+// timestamp, so it is kept for inspection but no longer fails every load.
+// Its replacement is the backup if that can be read, or a fresh state with
+// the items that can still be read from the damaged data. The replacement
+// is saved right away and OnRecover is told what happened.
+func (s *JSONStorage) recoverFile(data []byte, cause error) (*State, error) {
+	r := Recovery{
+		Err:         cause,
+		Quarantined: quarantinePath(s.Path, time.Now()),
+	}
+	if err := os.Rename(s.Path, r.Quarantined); err != nil {
+		return nil, fmt.Errorf("%s: %w (moving it aside failed: %v)", s.Path, cause, err)
+	}
+	s.remember(nil)
+
+	var state *State
+	if backup, err := os.ReadFile(s.Path + ".bak"); err == nil {
+		state, _ = parseState(backup)
+	}
+	if state != nil {
+		r.FromBackup = true
+	} else {
+		state = &State{Items: salvageItems(data), Active: false, Mode: ModeQueue}
+	}
+	r.Items = len(state.Items)
+	if err := s.Save(state); err != nil {
+		return nil, fmt.Errorf("%s: saving the recovered state: %w", s.Path, err)
+	}
+	if s.OnRecover != nil {
+		s.OnRecover(r)
+	}
+	return state, nil
+}
+
+// quarantinePath returns a name to move the damaged file at path to that
+// is not taken yet, so an earlier damaged file is never overwritten.
+func quarantinePath(path string, now time.Time) string {
+	name := fmt.Sprintf("%s.corrupt-%s", path, now.Format("20060102-150405.000"))
+	for i, try := 2, name; ; i++ {
+		if _, err := os.Lstat(try); os.IsNotExist(err) {
+			return try
+		}
+		try = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+// salvageItems returns the items of a damaged state file up to the first
+// one that cannot be read.
+func salvageItems(data []byte) []Item {
+	items := []Item{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return items
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return items
+		}
+		if key != "items" {
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return items
+			}
+			continue
+		}
+		if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+			return items
+		}
+		for dec.More() {
+			var item Item
+			if err := dec.Decode(&item); err != nil {
+				break
+			}
+			items = append(items, item)
+		}
+		break
+	}
+	fillIDs(items)
+	return items
+}
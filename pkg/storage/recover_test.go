@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONStorage_RecoverDamagedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	damaged := `{"items": [{"id": "1", "text": "a"}, "b", {"id": "3", "te`
+	os.WriteFile(path, []byte(damaged), 0644)
+
+	var recoveries []Recovery
+	s := NewJSONStorage(path)
+	s.OnRecover = func(r Recovery) { recoveries = append(recoveries, r) }
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(Texts(state.Items), []string{"a", "b"}) || state.Items[1].ID == "" || state.Active {
+		t.Errorf("expected the readable items in a stopped queue, got %+v", state)
+	}
+	if len(recoveries) != 1 || recoveries[0].FromBackup || recoveries[0].Items != 2 {
+		t.Fatalf("unexpected recoveries %+v", recoveries)
+	}
+
+	// The damaged file is kept aside and replaced by the recovered state.
+	r := recoveries[0]
+	if kept, err := os.ReadFile(r.Quarantined); err != nil || string(kept) != damaged {
+		t.Errorf("expected the damaged file at %s: %v", r.Quarantined, err)
+	}
+	if !strings.Contains(r.String(), r.Quarantined) {
+		t.Errorf("message does not say where the file went: %s", r)
+	}
+	if again, err := NewJSONStorage(path).Load(); err != nil || len(again.Items) != 2 {
+		t.Errorf("expected the recovered state saved, got %+v: %v", again, err)
+	}
+}
+
+// Files damaged in quick succession are all kept.
+func TestJSONStorage_RecoverTwice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	var kept []string
+	s := NewJSONStorage(path)
+	s.OnRecover = func(r Recovery) { kept = append(kept, r.Quarantined) }
+	for _, damaged := range []string{`{"items": [`, `{"items": [{`} {
+		os.WriteFile(path, []byte(damaged), 0644)
+		os.Remove(path + ".bak")
+		if _, err := s.Load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(kept) != 2 || kept[0] == kept[1] {
+		t.Fatalf("expected two quarantined files, got %q", kept)
+	}
+	for i, damaged := range []string{`{"items": [`, `{"items": [{`} {
+		if data, err := os.ReadFile(kept[i]); err != nil || string(data) != damaged {
+			t.Errorf("%s: got %q, %v", kept[i], data, err)
+		}
+	}
+
+	now := time.Date(2026, 1, 2, 15, 4, 5, 0, time.Local)
+	first := quarantinePath(path, now)
+	os.WriteFile(first, nil, 0644)
+	if second := quarantinePath(path, now); second != first+"-2" {
+		t.Errorf("expected %s-2, got %s", first, second)
+	}
+}
+
+func TestSalvageItems(t *testing.T) {
+	for data, want := range map[string][]string{
+		"":                                 {},
+		"[1, 2]":                           {},
+		`{"active": true, "items": ["a", `: {"a"},
+		`{"active": tr`:                    {},
+		`{"items": ["a", 7, "c"]}`:         {"a"},
+	} {
+		if got := Texts(salvageItems([]byte(data))); !slices.Equal(got, want) {
+			t.Errorf("salvageItems(%q) = %q, want %q", data, got, want)
+		}
+	}
+}
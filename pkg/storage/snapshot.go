@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultBackups is how many snapshots are kept unless configured
+// otherwise.
+const DefaultBackups = 20
+
+// snapshotLayout names snapshot files so they sort by time.
+const snapshotLayout = "2006-01-02T150405.000"
+
+// Snapshots keeps timestamped copies of the state as JSON files in Dir,
+// deleting all but the newest Keep.
+type Snapshots struct {
+	Dir  string
+	Keep int
+	// Blobs, if set, keeps the text of large items in its blob files, to
+	// which the snapshots then refer, instead of in every snapshot. Its Base
+	// is not used.
+	Blobs *BlobStorage
+}
+
+func NewSnapshots(dir string, keep int) *Snapshots {
+	return &Snapshots{Dir: dir, Keep: keep}
+}
+
+// Snapshot describes a stored copy of the state.
+type Snapshot struct {
+	Name   string // the file name without .json, e.g. 2026-01-02T150405.000-activate
+	Time   time.Time
+	Reason string // why it was taken, e.g. activate or periodic
+	Items  int
+}
+
+// Take stores a copy of state, unless it equals the newest snapshot, and
+// returns the snapshot holding it.
+func (s *Snapshots) Take(state *State, reason string, now time.Time) (Snapshot, error) {
+	stored := state
+	if s.Blobs != nil {
+		var err error
+		if stored, _, err = s.Blobs.deflateState(state); err != nil {
+			return Snapshot{}, err
+		}
+	}
+	data, err := json.MarshalIndent(stored, "", "  ")
+	if err != nil {
+		return Snapshot{}, err
+	}
+	list, err := s.list(false)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if len(list) > 0 {
+		newest, err := os.ReadFile(s.path(list[0].Name))
+		if err == nil && bytes.Equal(newest, data) {
+			list[0].Items = len(state.Items)
+			return list[0], nil
+		}
+	}
+
+	snap := Snapshot{
+		Name:   now.UTC().Format(snapshotLayout) + "-" + reason,
+		Time:   now,
+		Reason: reason,
+		Items:  len(state.Items),
+	}
+	if err := WriteFileAtomic(s.path(snap.Name), data); err != nil {
+		return Snapshot{}, err
+	}
+	list = append([]Snapshot{snap}, list...)
+	for _, old := range list[min(max(s.Keep, 1), len(list)):] {
+		if err := os.Remove(s.path(old.Name)); err != nil && !os.IsNotExist(err) {
+			return snap, err
+		}
+	}
+	return snap, nil
+}
+
+// List returns the snapshots, newest first.
+func (s *Snapshots) List() ([]Snapshot, error) {
+	return s.list(true)
+}
+
+// list returns the snapshots, newest first, reading each one to count its
+// items only if count is set.
+func (s *Snapshots) list(count bool) ([]Snapshot, error) {
+	entries, err := os.ReadDir(s.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Snapshot
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || len(name) <= len(snapshotLayout) {
+			continue
+		}
+		t, err := time.Parse(snapshotLayout, name[:len(snapshotLayout)])
+		if err != nil {
+			continue
+		}
+		snap := Snapshot{Name: name, Time: t, Reason: strings.TrimPrefix(name[len(snapshotLayout):], "-")}
+		if count {
+			if state, err := s.read(name); err == nil {
+				snap.Items = len(state.Items)
+			}
+		}
+		list = append(list, snap)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name > list[j].Name })
+	return list, nil
+}
+
+// Load returns the state stored in the snapshot with the given name, or
+// the only one whose name starts with it.
+func (s *Snapshots) Load(name string) (*State, Snapshot, error) {
+	list, err := s.list(false)
+	if err != nil {
+		return nil, Snapshot{}, err
+	}
+	var matches []Snapshot
+	for _, snap := range list {
+		if snap.Name == name {
+			matches = []Snapshot{snap}
+			break
+		}
+		if strings.HasPrefix(snap.Name, name) {
+			matches = append(matches, snap)
+		}
+	}
+	switch {
+	case len(matches) == 0:
+		return nil, Snapshot{}, fmt.Errorf("no snapshot %q", name)
+	case len(matches) > 1:
+		return nil, Snapshot{}, fmt.Errorf("%q matches %d snapshots", name, len(matches))
+	}
+	state, err := s.read(matches[0].Name)
+	if err != nil {
+		return nil, Snapshot{}, err
+	}
+	if s.Blobs != nil {
+		if err := s.Blobs.inflate(state); err != nil {
+			return nil, Snapshot{}, fmt.Errorf("snapshot %s: %w", matches[0].Name, err)
+		}
+	}
+	matches[0].Items = len(state.Items)
+	return state, matches[0], nil
+}
+
+// blobRefs returns the blobs the snapshots refer to. Snapshots that cannot
+// be read are skipped.
+func (s *Snapshots) blobRefs() (map[string]bool, error) {
+	list, err := s.list(false)
+	if err != nil {
+		return nil, err
+	}
+	refs := map[string]bool{}
+	for _, snap := range list {
+		state, err := s.read(snap.Name)
+		if err != nil {
+			continue
+		}
+		maps.Copy(refs, blobRefs(state))
+	}
+	return refs, nil
+}
+
+func (s *Snapshots) read(name string) (*State, error) {
+	data, err := os.ReadFile(s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	state, err := parseState(data)
+	if err != nil {
+		return nil, fmt.Errorf("snapshot %s: %w", name, err)
+	}
+	return state, nil
+}
+
+func (s *Snapshots) path(name string) string {
+	return filepath.Join(s.Dir, name+".json")
+}
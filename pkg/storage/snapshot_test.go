@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSnapshots(t *testing.T) {
+	s := NewSnapshots(t.TempDir(), 2)
+	if list, err := s.List(); err != nil || len(list) != 0 {
+		t.Fatalf("expected no snapshots, got %v: %v", list, err)
+	}
+
+	start := time.Date(2026, 1, 2, 15, 4, 5, 0, time.UTC)
+	state := &State{Items: []Item{NewItem("a")}, Active: true}
+	first, err := s.Take(state, "activate", start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Name != "2026-01-02T150405.000-activate" || first.Items != 1 {
+		t.Errorf("unexpected snapshot %+v", first)
+	}
+
+	// An unchanged state is not stored again.
+	if again, err := s.Take(state, "periodic", start.Add(time.Minute)); err != nil || again.Name != first.Name || again.Items != 1 {
+		t.Errorf("expected the first snapshot, got %+v: %v", again, err)
+	}
+
+	// Only the newest two are kept.
+	for i, text := range []string{"b", "c"} {
+		state.Items = append(state.Items, NewItem(text))
+		if _, err := s.Take(state, "periodic", start.Add(time.Duration(i+2)*time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list, err := s.List()
+	if err != nil || len(list) != 2 || list[0].Items != 3 || list[1].Items != 2 || list[0].Reason != "periodic" {
+		t.Fatalf("unexpected snapshots %+v: %v", list, err)
+	}
+	if entries, _ := os.ReadDir(s.Dir); len(entries) != 2 {
+		t.Errorf("expected two files, got %d", len(entries))
+	}
+
+	got, snap, err := s.Load("2026-01-02T1506")
+	if err != nil || snap.Name != list[1].Name || len(got.Items) != 2 || !got.Active {
+		t.Errorf("got %+v from %+v: %v", got, snap, err)
+	}
+	if _, _, err := s.Load("2026"); err == nil {
+		t.Error("expected an error for an ambiguous prefix")
+	}
+	if _, _, err := s.Load("1999"); err == nil {
+		t.Error("expected an error for no match")
+	}
+}
+
+func TestSnapshots_Blobs(t *testing.T) {
+	dir := t.TempDir()
+	cfg := &Config{BlobThreshold: 10}
+	s, snapshots := cfg.Open(dir), cfg.Snapshots(dir)
+	big := strings.Repeat("big ", 10)
+
+	state := &State{Items: []Item{NewItem(big)}, Active: true}
+	if err := s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := snapshots.Take(state, "activate", time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(snapshots.Dir, snap.Name+".json"))
+	if strings.Contains(string(data), big) {
+		t.Error("expected the snapshot to refer to the blob, not to hold the text")
+	}
+
+	// The queue drops the item; its blob stays for the snapshot.
+	for range 2 {
+		if err := s.Save(&State{Items: []Item{NewItem("small")}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restored, _, err := snapshots.Load(snap.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored.Items) != 1 || restored.Items[0].Text != big || restored.Items[0].Blob != "" {
+		t.Errorf("expected the item read back from its blob, got %+v", restored.Items)
+	}
+
+	// Once the snapshot is gone, so is the blob.
+	os.Remove(filepath.Join(snapshots.Dir, snap.Name+".json"))
+	if err := s.Save(&State{Items: []Item{NewItem("small")}}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(dir, "blobs")); len(entries) != 0 {
+		t.Errorf("expected the blob collected, found %d files", len(entries))
+	}
+}
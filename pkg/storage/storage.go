@@ -1,15 +1,177 @@
 package storage
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"sync"
+	"time"
 )
 
+// Mode is the order in which items are pasted.
+type Mode string
+
+const (
+	ModeQueue    Mode = "queue"    // first in, first out
+	ModeStack    Mode = "stack"    // last in, first out
+	ModePriority Mode = "priority" // by descending priority, with pinned items at their PinPosition
+	ModeShuffle  Mode = "shuffle"  // in random order
+)
+
+// Modes lists every mode.
+var Modes = []Mode{ModeQueue, ModeStack, ModePriority, ModeShuffle}
+
+// ParseMode validates a mode name.
+func ParseMode(s string) (Mode, error) {
+	for _, m := range Modes {
+		if string(m) == s {
+			return m, nil
+		}
+	}
+	return "", fmt.Errorf("unknown mode %q (want queue, stack, priority or shuffle)", s)
+}
+
+// PinPosition is where pinned items are pasted relative to the others.
+type PinPosition string
+
+const (
+	PinFirst PinPosition = "first"
+	PinLast  PinPosition = "last"
+)
+
+// Label describes the mode for display.
+func (m Mode) Label() string {
+	switch m {
+	case ModeStack:
+		return "stack (LIFO)"
+	case ModePriority:
+		return "priority"
+	case ModeShuffle:
+		return "shuffle"
+	}
+	return "queue (FIFO)"
+}
+
 type State struct {
-	Items   []string `json:"items"`
-	Active  bool     `json:"active"`
-	IsStack bool     `json:"is_stack"`
+	Items  []Item `json:"items"`
+	Active bool   `json:"active"`
+	// Mode is the paste order; empty means ModeQueue.
+	Mode Mode `json:"mode"`
+	// Modes are the modes Cmd+M cycles through; empty means queue and stack.
+	Modes []Mode `json:"modes,omitempty"`
+	// Pins are the pinned items, restored into Items whenever the queue is
+	// cleared, and PinPosition where they are pasted; empty means PinFirst.
+	Pins        []Item      `json:"pins,omitempty"`
+	PinPosition PinPosition `json:"pin_position,omitempty"`
+	// Seed drives the order of a shuffled queue.
+	Seed uint64 `json:"seed,omitempty"`
+	// Cycle keeps pasted items, moving Cursor (a position in paste order)
+	// forward and wrapping around at the end. A shuffled queue counts its
+	// draws in Cursor.
+	Cycle  bool `json:"cycle,omitempty"`
+	Cursor int  `json:"cursor,omitempty"`
+	// Repeated counts how often the next item has been pasted so far, for
+	// items with a Repeat count.
+	Repeated int `json:"repeated,omitempty"`
+	// Split is the delimiter spec captured text is split on, empty if off.
+	Split string `json:"split,omitempty"`
+	// JoinWith is the separator or template used when joining items.
+	JoinWith string `json:"join_with,omitempty"`
+	// CaptureTransforms and PasteTransforms name the transformations applied
+	// to text when it is captured and when it is put on the clipboard.
+	CaptureTransforms []string `json:"capture_transforms,omitempty"`
+	PasteTransforms   []string `json:"paste_transforms,omitempty"`
+	// Template is a text/template the next item is rendered with before it
+	// is put on the clipboard, empty to paste items as they are.
+	Template string `json:"template,omitempty"`
+	// Pasted counts the items pasted since the queue was activated.
+	Pasted int `json:"pasted,omitempty"`
+	// Generator is a pattern the queue generates its items from instead of
+	// capturing them, and GeneratorPos the position of the next value.
+	Generator    string `json:"generator,omitempty"`
+	GeneratorPos int    `json:"generator_pos,omitempty"`
+	// History holds the items most recently pasted off the queue, oldest
+	// first. Clearing the queue keeps it.
+	History []Item `json:"history,omitempty"`
+}
+
+// UnmarshalJSON also accepts the is_stack flag state files used before
+// there were more than two modes.
+func (s *State) UnmarshalJSON(data []byte) error {
+	type plain State
+	legacy := struct {
+		*plain
+		IsStack bool `json:"is_stack"`
+	}{plain: (*plain)(s)}
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return err
+	}
+	if s.Mode == "" {
+		s.Mode = ModeQueue
+		if legacy.IsStack {
+			s.Mode = ModeStack
+		}
+	}
+	return nil
+}
+
+// Item is a single queued clipboard value.
+type Item struct {
+	ID      string    `json:"id"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created,omitzero"`
+	// Priority orders items in priority mode, highest first.
+	Priority int `json:"priority,omitempty"`
+	// Pinned items are pasted before or after all others (see
+	// State.PinPosition) and come back whenever the queue is cleared.
+	Pinned bool `json:"pinned,omitempty"`
+	// Repeat is how many times the item is pasted before moving on to the
+	// next one; 0 and 1 both mean once.
+	Repeat int `json:"repeat,omitempty"`
+	// Meta holds arbitrary key/value pairs, available to paste templates.
+	Meta map[string]string `json:"meta,omitempty"`
+	// Blob, if set, names the blob file holding Text, which is then empty.
+	// It is only ever seen by the storage a BlobStorage wraps.
+	Blob string `json:"blob,omitempty"`
+}
+
+// NewItem returns an item with a fresh ID, captured now.
+func NewItem(text string) Item {
+	return Item{ID: NewID(), Text: text, Created: time.Now()}
+}
+
+// NewID returns a short random identifier for an item.
+func NewID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// UnmarshalJSON also accepts a plain string, the format state files used
+// before items had IDs.
+func (i *Item) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		*i = Item{Text: text}
+		return nil
+	}
+	type plain Item
+	return json.Unmarshal(data, (*plain)(i))
+}
+
+// Texts returns the text of every item, in order.
+func Texts(items []Item) []string {
+	out := make([]string, len(items))
+	for i, item := range items {
+		out[i] = item.Text
+	}
+	return out
 }
 
 type Storage interface {
@@ -18,8 +180,86 @@ type Storage interface {
 	Clear() error
 }
 
+// Locker is implemented by storages that several processes can share.
+// While a caller holds the lock, no other process can load or save, so a
+// load, change and save form a transaction.
+type Locker interface {
+	// Lock blocks until the caller holds the exclusive lock and returns a
+	// function releasing it.
+	Lock() (unlock func(), err error)
+}
+
+// ChangeDetector is implemented by storages that can tell whether the
+// stored state was changed by someone else, such as another process or a
+// text editor, since they last loaded or saved it.
+type ChangeDetector interface {
+	Changed() (bool, error)
+}
+
+// CreatedIndex is implemented by storages that can look items up by the
+// time they were created without loading the whole queue.
+type CreatedIndex interface {
+	CreatedBetween(from, to time.Time) ([]Item, error)
+}
+
+// CreatedBetween returns the items of s created in [from, to), oldest
+// first, through its index if it has one.
+func CreatedBetween(s Storage, from, to time.Time) ([]Item, error) {
+	if idx, ok := s.(CreatedIndex); ok {
+		return idx.CreatedBetween(from, to)
+	}
+	state, err := s.Load()
+	if err != nil {
+		return nil, err
+	}
+	var out []Item
+	for _, item := range state.Items {
+		if !item.Created.Before(from) && item.Created.Before(to) {
+			out = append(out, item)
+		}
+	}
+	slices.SortStableFunc(out, func(a, b Item) int { return a.Created.Compare(b.Created) })
+	return out, nil
+}
+
+// Durability is how far Save goes to make sure a write survives a crash or
+// power loss.
+type Durability string
+
+const (
+	// SyncNone leaves flushing to the operating system; a power loss can
+	// leave an empty or partial file.
+	SyncNone Durability = "none"
+	// SyncFile flushes the new file to disk before it replaces the old one,
+	// so the file is always complete, though a power loss may undo the
+	// latest save.
+	SyncFile Durability = "file"
+	// SyncDir also flushes the directory, so a save is durable once it
+	// returns.
+	SyncDir Durability = "file+dir"
+)
+
+// ParseDurability validates a durability level.
+func ParseDurability(s string) (Durability, error) {
+	switch d := Durability(s); d {
+	case SyncNone, SyncFile, SyncDir:
+		return d, nil
+	}
+	return "", fmt.Errorf("unknown durability %q (want none, file or file+dir)", s)
+}
+
+// JSONStorage keeps the state in a JSON file, next to a backup of the
+// previous version (Path + ".bak") that Load falls back to if the file is
+// damaged.
 type JSONStorage struct {
 	Path string
+	// Durability is how Save writes the file; empty means SyncFile.
+	Durability Durability
+	// OnRecover, if set, is called when Load recovers from a damaged file.
+	OnRecover func(Recovery)
+
+	mu   sync.Mutex
+	seen os.FileInfo // the state file as last loaded or saved
 }
 
 func NewJSONStorage(path string) *JSONStorage {
@@ -27,42 +267,154 @@ func NewJSONStorage(path string) *JSONStorage {
 }
 
 func GetDefaultPath() (string, error) {
-	home, err := os.UserHomeDir()
+	dir, err := DefaultDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(home, ".cbq", "state.json"), nil
+	return filepath.Join(dir, "state.json"), nil
 }
 
+// Load reads the state file. If it cannot be parsed, as after a crash
+// while writing it without SyncFile, it is recovered (see recoverFile).
 func (s *JSONStorage) Load() (*State, error) {
-	if _, err := os.Stat(s.Path); os.IsNotExist(err) {
-		return &State{Items: []string{}, Active: false}, nil
+	f, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		s.remember(nil)
+		return &State{Items: []Item{}, Active: false, Mode: ModeQueue}, nil
 	}
-	data, err := os.ReadFile(s.Path)
 	if err != nil {
 		return nil, err
 	}
+	// Closed before parsing, so a damaged file can be moved aside.
+	info, data, err := readOpen(f)
+	if err != nil {
+		return nil, err
+	}
+	state, err := parseState(data)
+	if err != nil {
+		return s.recoverFile(data, err)
+	}
+	s.remember(info)
+	return state, nil
+}
+
+// readOpen reads and closes f.
+func readOpen(f *os.File) (os.FileInfo, []byte, error) {
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := io.ReadAll(f)
+	return info, data, err
+}
+
+func parseState(data []byte) (*State, error) {
 	var state State
 	if err := json.Unmarshal(data, &state); err != nil {
 		return nil, err
 	}
 	if state.Items == nil {
-		state.Items = []string{}
+		state.Items = []Item{}
 	}
+	fillIDs(state.Items)
 	return &state, nil
 }
 
+// fillIDs gives items from state files written before items had IDs one.
+func fillIDs(items []Item) {
+	for i := range items {
+		if items[i].ID == "" {
+			// Derived rather than random so the ID is stable across loads
+			// until the state is next saved.
+			sum := sha256.Sum256(fmt.Appendf(nil, "%d\x00%s", i, items[i].Text))
+			items[i].ID = hex.EncodeToString(sum[:4])
+		}
+	}
+}
+
 // Save writes state atomically via a temp file + rename to prevent corruption on crash.
+// The file being replaced becomes the backup if it was read or written
+// through s and has not changed since.
 func (s *JSONStorage) Save(state *State) error {
-	dir := filepath.Dir(s.Path)
-	if err := os.MkdirAll(dir, 0755); err != nil {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
 		return err
 	}
-	data, err := json.MarshalIndent(state, "", "  ")
+	s.backup()
+	if err := writeFileAtomic(s.Path, data, s.Durability); err != nil {
+		return err
+	}
+	info, err := os.Stat(s.Path)
 	if err != nil {
 		return err
 	}
-	tmp, err := os.CreateTemp(dir, ".cbq-state-*.tmp")
+	s.remember(info)
+	return nil
+}
+
+// backup hard-links the state file to the backup path, on a best-effort
+// basis: a save does not fail for want of a backup.
+func (s *JSONStorage) backup() {
+	s.mu.Lock()
+	seen := s.seen
+	s.mu.Unlock()
+	if changed, err := s.Changed(); seen == nil || changed || err != nil {
+		return
+	}
+	bak := s.Path + ".bak"
+	_ = os.Remove(bak)
+	_ = os.Link(s.Path, bak)
+}
+
+// Lock takes an exclusive lock shared by every process using the same state
+// file, blocking until it is available. The lock is on a separate file next
+// to the state, since Save replaces the state file and a lock on the old one
+// would not exclude anybody.
+func (s *JSONStorage) Lock() (unlock func(), err error) {
+	return lockFile(s.Path + ".lock")
+}
+
+// Changed reports whether the state file was replaced, modified or removed
+// since it was last loaded or saved through s. Save always replaces the
+// file, so changes by other processes are caught even within the
+// resolution of modification times.
+func (s *JSONStorage) Changed() (bool, error) {
+	info, err := os.Stat(s.Path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case info == nil || s.seen == nil:
+		return info != s.seen, nil
+	case !os.SameFile(info, s.seen):
+		return true, nil
+	}
+	return !info.ModTime().Equal(s.seen.ModTime()) || info.Size() != s.seen.Size(), nil
+}
+
+func (s *JSONStorage) remember(info os.FileInfo) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seen = info
+}
+
+// WriteFileAtomic replaces the file at path with data via a temp file in
+// the same directory and a rename, so readers never see a partial file.
+// Missing parent directories are created. The data is flushed to disk
+// before the rename (SyncFile).
+func WriteFileAtomic(path string, data []byte) error {
+	return writeFileAtomic(path, data, SyncFile)
+}
+
+func writeFileAtomic(path string, data []byte, d Durability) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".cbq-"+filepath.Base(path)+"-*.tmp")
 	if err != nil {
 		return err
 	}
@@ -73,17 +425,46 @@ func (s *JSONStorage) Save(state *State) error {
 		tmp.Close()
 		return err
 	}
+	if d != SyncNone {
+		if err = tmp.Sync(); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
 	if err = tmp.Close(); err != nil {
 		return err
 	}
-	return os.Rename(tmpName, s.Path)
+	if err = os.Rename(tmpName, path); err != nil {
+		return err
+	}
+	if d == SyncDir {
+		return syncDir(dir)
+	}
+	return nil
+}
+
+// syncDir flushes a directory, making renames in it durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
 }
 
+// Clear empties the queue, keeping pinned items.
 func (s *JSONStorage) Clear() error {
 	state, err := s.Load()
 	if err != nil {
 		return err
 	}
-	state.Items = []string{}
+	state.Items = slices.Clone(state.Pins)
+	if state.Items == nil {
+		state.Items = []Item{}
+	}
 	return s.Save(state)
 }
@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestJSONStorage_SaveAndLoad(t *testing.T) {
@@ -17,7 +18,7 @@ func TestJSONStorage_SaveAndLoad(t *testing.T) {
 	s := NewJSONStorage(path)
 
 	state := &State{
-		Items:  []string{"item1", "item2"},
+		Items:  []Item{NewItem("item1"), NewItem("item2")},
 		Active: true,
 	}
 
@@ -34,7 +35,7 @@ func TestJSONStorage_SaveAndLoad(t *testing.T) {
 	if !loaded.Active {
 		t.Errorf("expected active to be true")
 	}
-	if len(loaded.Items) != 2 || loaded.Items[0] != "item1" || loaded.Items[1] != "item2" {
+	if len(loaded.Items) != 2 || loaded.Items[0].Text != "item1" || loaded.Items[1].Text != "item2" {
 		t.Errorf("loaded items incorrect: %v", loaded.Items)
 	}
 }
@@ -56,8 +57,8 @@ func TestJSONStorage_Clear(t *testing.T) {
 	path := filepath.Join(tmpDir, "state.json")
 	s := NewJSONStorage(path)
 
-	s.Save(&State{Items: []string{"a", "b"}, Active: true})
-	
+	s.Save(&State{Items: []Item{NewItem("a"), NewItem("b")}, Active: true})
+
 	err := s.Clear()
 	if err != nil {
 		t.Fatalf("failed to clear: %v", err)
@@ -71,3 +72,181 @@ func TestJSONStorage_Clear(t *testing.T) {
 		t.Error("expected active state to be preserved after clear")
 	}
 }
+
+func TestJSONStorage_LoadLegacyItems(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := os.WriteFile(path, []byte(`{"items":["a","b"],"active":true,"is_stack":false}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewJSONStorage(path)
+
+	first, err := s.Load()
+	if err != nil {
+		t.Fatalf("failed to load legacy state: %v", err)
+	}
+	if len(first.Items) != 2 || first.Items[0].Text != "a" || first.Items[1].Text != "b" {
+		t.Fatalf("legacy items not decoded: %+v", first.Items)
+	}
+	if first.Items[0].ID == "" || first.Items[0].ID == first.Items[1].ID {
+		t.Errorf("expected distinct IDs, got %+v", first.Items)
+	}
+
+	second, _ := s.Load()
+	if second.Items[0].ID != first.Items[0].ID {
+		t.Errorf("legacy item IDs not stable across loads")
+	}
+}
+
+func TestJSONStorage_LoadLegacyStackFlag(t *testing.T) {
+	dir := t.TempDir()
+	for legacy, want := range map[string]Mode{
+		`{"items":[],"active":true,"is_stack":true}`:                   ModeStack,
+		`{"items":[],"active":true,"is_stack":false}`:                  ModeQueue,
+		`{"items":[],"active":true,"is_stack":true,"mode":"priority"}`: ModePriority,
+	} {
+		path := filepath.Join(dir, "state.json")
+		if err := os.WriteFile(path, []byte(legacy), 0644); err != nil {
+			t.Fatal(err)
+		}
+		state, err := NewJSONStorage(path).Load()
+		if err != nil {
+			t.Fatalf("%s: %v", legacy, err)
+		}
+		if state.Mode != want || !state.Active {
+			t.Errorf("%s: got mode %q, active %v; want %q", legacy, state.Mode, state.Active, want)
+		}
+	}
+
+	if _, err := ParseMode("lifo"); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+func TestJSONStorage_Lock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cbq", "state.json")
+	a, b := NewJSONStorage(path), NewJSONStorage(path)
+
+	unlock, err := a.Lock()
+	if err != nil {
+		t.Fatalf("failed to lock: %v", err)
+	}
+	acquired := make(chan func())
+	go func() {
+		unlockB, err := b.Lock()
+		if err != nil {
+			t.Error(err)
+		}
+		acquired <- unlockB
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("second lock acquired while the first was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	select {
+	case unlockB := <-acquired:
+		unlockB()
+	case <-time.After(5 * time.Second):
+		t.Fatal("second lock not acquired after the first was released")
+	}
+}
+
+func TestJSONStorage_Changed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s, other := NewJSONStorage(path), NewJSONStorage(path)
+
+	if changed, err := s.Changed(); err != nil || changed {
+		t.Fatalf("missing file never loaded: changed=%v, %v", changed, err)
+	}
+	if err := s.Save(&State{Items: []Item{NewItem("a")}}); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Changed(); changed {
+		t.Error("own save reported as a change")
+	}
+
+	// Another storage replacing the file.
+	if err := other.Save(&State{Items: []Item{NewItem("b")}}); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Changed(); !changed {
+		t.Error("replacement by another writer not detected")
+	}
+	if _, err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if changed, _ := s.Changed(); changed {
+		t.Error("change still reported after loading")
+	}
+
+	// An in-place edit, as by some text editors.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("\n")
+	f.Close()
+	if changed, _ := s.Changed(); !changed {
+		t.Error("in-place edit not detected")
+	}
+
+	os.Remove(path)
+	if changed, _ := s.Changed(); !changed {
+		t.Error("removal not detected")
+	}
+}
+
+func TestJSONStorage_Durability(t *testing.T) {
+	for _, d := range []Durability{"", SyncNone, SyncFile, SyncDir} {
+		s := NewJSONStorage(filepath.Join(t.TempDir(), "state.json"))
+		s.Durability = d
+		if err := s.Save(&State{Items: []Item{NewItem("a")}}); err != nil {
+			t.Fatalf("%q: %v", d, err)
+		}
+		if got, err := s.Load(); err != nil || len(got.Items) != 1 {
+			t.Errorf("%q: got %+v: %v", d, got, err)
+		}
+	}
+	if _, err := ParseDurability("sometimes"); err == nil {
+		t.Error("expected an error for an unknown durability")
+	}
+}
+
+func TestJSONStorage_RecoverFromBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	s := NewJSONStorage(path)
+	for _, text := range []string{"first", "second"} {
+		if err := s.Save(&State{Items: []Item{NewItem(text)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A power loss during a save without SyncFile.
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	var recovery Recovery
+	recovering := NewJSONStorage(path)
+	recovering.OnRecover = func(r Recovery) { recovery = r }
+	state, err := recovering.Load()
+	if err != nil || state.Items[0].Text != "first" {
+		t.Fatalf("expected the backup, got %+v: %v", state, err)
+	}
+	if !recovery.FromBackup || recovery.Items != 1 {
+		t.Errorf("unexpected recovery %+v", recovery)
+	}
+
+	// The damaged file does not replace the backup.
+	r := NewJSONStorage(path)
+	if _, err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Save(&State{Items: []Item{NewItem("third")}}); err != nil {
+		t.Fatal(err)
+	}
+	if backup, err := NewJSONStorage(path + ".bak").Load(); err != nil || backup.Items[0].Text != "first" {
+		t.Errorf("expected the backup kept, got %+v: %v", backup, err)
+	}
+}
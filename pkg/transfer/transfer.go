@@ -0,0 +1,217 @@
+// Package transfer reads and writes queue items in common interchange
+// formats so a queue can be seeded from a file or saved to one.
+package transfer
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+// Format is a file layout for a list of items.
+type Format string
+
+const (
+	Lines  Format = "lines"  // one item per line
+	NUL    Format = "nul"    // items terminated by NUL bytes, as with find -print0
+	JSON   Format = "json"   // a JSON array of strings
+	NDJSON Format = "ndjson" // one JSON string per line
+	CSV    Format = "csv"    // one item per row, taken from a single column
+)
+
+// Formats lists every supported format.
+var Formats = []Format{Lines, NUL, JSON, NDJSON, CSV}
+
+// ParseFormat validates a format name.
+func ParseFormat(s string) (Format, error) {
+	for _, f := range Formats {
+		if string(f) == s {
+			return f, nil
+		}
+	}
+	return "", fmt.Errorf("unknown format %q (want lines, nul, json, ndjson or csv)", s)
+}
+
+// FormatForPath guesses the format from a file extension, defaulting to Lines.
+func FormatForPath(path string) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON
+	case ".ndjson", ".jsonl":
+		return NDJSON
+	case ".csv":
+		return CSV
+	}
+	return Lines
+}
+
+// Options tune how items are read.
+type Options struct {
+	Column     int  // CSV column holding the item, zero-based
+	SkipHeader bool // CSV: ignore the first row
+}
+
+// Read decodes items from r. Empty items are skipped.
+func Read(r io.Reader, f Format, opts Options) ([]string, error) {
+	var items []string
+	var err error
+	switch f {
+	case Lines:
+		items, err = readDelimited(r, '\n')
+		for i, item := range items {
+			items[i] = strings.TrimSuffix(item, "\r")
+		}
+	case NUL:
+		items, err = readDelimited(r, 0)
+	case JSON:
+		var decoded []storage.Item
+		if err := json.NewDecoder(r).Decode(&decoded); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %w", err)
+		}
+		items = storage.Texts(decoded)
+	case NDJSON:
+		items, err = readNDJSON(r)
+	case CSV:
+		items, err = readCSV(r, opts)
+	default:
+		return nil, fmt.Errorf("unknown format %q", f)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nonEmpty(items), nil
+}
+
+// Write encodes items to w. Lines cannot represent items containing
+// newlines; those are rejected rather than silently split on re-import.
+func Write(w io.Writer, items []string, f Format) error {
+	bw := bufio.NewWriter(w)
+	switch f {
+	case Lines:
+		for i, item := range items {
+			if strings.ContainsAny(item, "\r\n") {
+				return fmt.Errorf("item %d spans several lines; use the nul, json, ndjson or csv format", i)
+			}
+			bw.WriteString(item)
+			bw.WriteByte('\n')
+		}
+	case NUL:
+		for i, item := range items {
+			if strings.IndexByte(item, 0) >= 0 {
+				return fmt.Errorf("item %d contains a NUL byte", i)
+			}
+			bw.WriteString(item)
+			bw.WriteByte(0)
+		}
+	case JSON:
+		enc := json.NewEncoder(bw)
+		enc.SetIndent("", "  ")
+		if items == nil {
+			items = []string{}
+		}
+		if err := enc.Encode(items); err != nil {
+			return err
+		}
+	case NDJSON:
+		enc := json.NewEncoder(bw)
+		for _, item := range items {
+			if err := enc.Encode(item); err != nil {
+				return err
+			}
+		}
+	case CSV:
+		cw := csv.NewWriter(bw)
+		for _, item := range items {
+			if err := cw.Write([]string{item}); err != nil {
+				return err
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown format %q", f)
+	}
+	return bw.Flush()
+}
+
+// Export writes the items of state, in queue order.
+func Export(w io.Writer, state *storage.State, f Format) error {
+	return Write(w, storage.Texts(state.Items), f)
+}
+
+// ExportHistory writes the items pasted off state's queue, oldest first,
+// so that Read returns them in the order to pass to queue.AddHistory.
+func ExportHistory(w io.Writer, state *storage.State, f Format) error {
+	return Write(w, storage.Texts(state.History), f)
+}
+
+func readDelimited(r io.Reader, delim byte) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(string(data), string(delim)), nil
+}
+
+func readNDJSON(r io.Reader) ([]string, error) {
+	var items []string
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for line := 1; sc.Scan(); line++ {
+		text := bytes.TrimSpace(sc.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		var item storage.Item
+		if err := json.Unmarshal(text, &item); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		items = append(items, item.Text)
+	}
+	return items, sc.Err()
+}
+
+func readCSV(r io.Reader, opts Options) ([]string, error) {
+	if opts.Column < 0 {
+		return nil, errors.New("CSV column must not be negative")
+	}
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1 // ragged rows are fine as long as the column exists
+	var items []string
+	for row := 1; ; row++ {
+		record, err := cr.Read()
+		if err == io.EOF {
+			return items, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if row == 1 && opts.SkipHeader {
+			continue
+		}
+		if opts.Column >= len(record) {
+			return nil, fmt.Errorf("row %d has no column %d", row, opts.Column)
+		}
+		items = append(items, record[opts.Column])
+	}
+}
+
+func nonEmpty(items []string) []string {
+	out := items[:0]
+	for _, item := range items {
+		if item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,118 @@
+package transfer
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+func TestRoundTrip(t *testing.T) {
+	items := []string{
+		"plain",
+		"multi\nline\nitem",
+		`quotes "and", commas`,
+		"  leading and trailing spaces  ",
+		"unicode ✓ — ü",
+	}
+	for _, f := range []Format{NUL, JSON, NDJSON, CSV} {
+		t.Run(string(f), func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Write(&buf, items, f); err != nil {
+				t.Fatalf("write: %v", err)
+			}
+			got, err := Read(&buf, f, Options{})
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if !slices.Equal(got, items) {
+				t.Errorf("round trip mismatch:\n got %q\nwant %q", got, items)
+			}
+		})
+	}
+}
+
+func TestLines(t *testing.T) {
+	got, err := Read(strings.NewReader("a\r\nb\n\nc\n"), Lines, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"a", "b", "c"}) {
+		t.Errorf("got %q", got)
+	}
+
+	var buf bytes.Buffer
+	if err := Write(&buf, []string{"a", "b"}, Lines); err != nil || buf.String() != "a\nb\n" {
+		t.Errorf("write: %q, %v", buf.String(), err)
+	}
+	if err := Write(&buf, []string{"multi\nline"}, Lines); err == nil {
+		t.Error("expected multi-line item to be rejected")
+	}
+}
+
+func TestCSVColumn(t *testing.T) {
+	in := "id,email\n1,a@example.com\n2,\"b@example.com\"\n"
+	got, err := Read(strings.NewReader(in), CSV, Options{Column: 1, SkipHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"a@example.com", "b@example.com"}) {
+		t.Errorf("got %q", got)
+	}
+	if _, err := Read(strings.NewReader(in), CSV, Options{Column: 5}); err == nil {
+		t.Error("expected error for missing column")
+	}
+}
+
+func TestJSONAcceptsItems(t *testing.T) {
+	in := `[{"id":"1","text":"a"}, "b"]`
+	got, err := Read(strings.NewReader(in), JSON, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, []string{"a", "b"}) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestExport(t *testing.T) {
+	state := &storage.State{Items: []storage.Item{storage.NewItem("a"), storage.NewItem("b\nc")}}
+	var buf bytes.Buffer
+	if err := Export(&buf, state, NDJSON); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "\"a\"\n\"b\\nc\"\n" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestExportHistory(t *testing.T) {
+	state := &storage.State{
+		Items:   []storage.Item{storage.NewItem("queued")},
+		History: []storage.Item{storage.NewItem("first"), storage.NewItem("second\nline")},
+	}
+	var buf bytes.Buffer
+	if err := ExportHistory(&buf, state, JSON); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(&buf, JSON, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, storage.Texts(state.History)) {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestFormatForPath(t *testing.T) {
+	cases := map[string]Format{
+		"ids.txt": Lines, "q.JSON": JSON, "q.jsonl": NDJSON, "sheet.csv": CSV, "": Lines,
+	}
+	for path, want := range cases {
+		if got := FormatForPath(path); got != want {
+			t.Errorf("%q: got %s, want %s", path, got, want)
+		}
+	}
+}
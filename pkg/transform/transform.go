@@ -0,0 +1,194 @@
+// Package transform provides named text transformations that can be chained
+// and applied to clipboard items when they are captured or pasted.
+//
+// Transformations never fail: input a transformation does not understand,
+// such as invalid JSON for json-pretty, is passed through unchanged so a
+// chain cannot block capturing or pasting.
+package transform
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Func transforms a single value.
+type Func func(string) string
+
+var registry = map[string]Func{
+	"trim":          strings.TrimSpace,
+	"lower":         strings.ToLower,
+	"upper":         strings.ToUpper,
+	"url-clean":     cleanURL,
+	"json-pretty":   prettyJSON,
+	"json-minify":   minifyJSON,
+	"shell-quote":   shellQuote,
+	"sql-quote":     sqlQuote,
+	"json-quote":    jsonQuote,
+	"base64-encode": func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) },
+	"base64-decode": decodeBase64,
+}
+
+// Names returns the available transformation names, sorted.
+func Names() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Lookup returns the transformation with the given name.
+func Lookup(name string) (Func, bool) {
+	f, ok := registry[name]
+	return f, ok
+}
+
+// TemplateFuncs returns every transformation as a text/template function,
+// named in camel case: sql-quote becomes sqlQuote.
+func TemplateFuncs() map[string]any {
+	funcs := make(map[string]any, len(registry))
+	for name, f := range registry {
+		words := strings.Split(name, "-")
+		for i := 1; i < len(words); i++ {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+		funcs[strings.Join(words, "")] = f
+	}
+	return funcs
+}
+
+// Chain is a sequence of transformation names applied in order.
+type Chain []string
+
+// Parse reads a comma-separated list of transformation names.
+func Parse(spec string) (Chain, error) {
+	var chain Chain
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if _, ok := registry[name]; !ok {
+			return nil, fmt.Errorf("unknown transform %q (available: %s)", name, strings.Join(Names(), ", "))
+		}
+		chain = append(chain, name)
+	}
+	return chain, nil
+}
+
+// Apply runs every transformation in the chain. Unknown names are skipped.
+func (c Chain) Apply(s string) string {
+	for _, name := range c {
+		if f, ok := registry[name]; ok {
+			s = f(s)
+		}
+	}
+	return s
+}
+
+// String returns the chain in the form accepted by Parse.
+func (c Chain) String() string {
+	return strings.Join(c, ",")
+}
+
+// trackingParams are query parameters that only identify where a link was
+// shared from.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "yclid": true,
+	"mc_cid": true, "mc_eid": true, "igshid": true, "_hsenc": true, "_hsmkt": true,
+	"si": true, "spm": true,
+}
+
+// cleanURL strips tracking parameters such as utm_* from a lone URL. The
+// other parameters are kept byte for byte and in their order, since
+// re-encoding them could change what the link points to.
+func cleanURL(s string) string {
+	trimmed := strings.TrimSpace(s)
+	u, err := url.Parse(trimmed)
+	if err != nil || u.Scheme == "" || u.Host == "" || u.RawQuery == "" || strings.ContainsAny(trimmed, " \n\t") {
+		return s
+	}
+	base, rest, _ := strings.Cut(trimmed, "?")
+	query, fragment, hasFragment := strings.Cut(rest, "#")
+	params := strings.Split(query, "&")
+	var kept []string
+	for _, param := range params {
+		key, _, _ := strings.Cut(param, "=")
+		if k, err := url.QueryUnescape(key); err == nil {
+			key = k
+		}
+		key = strings.ToLower(key)
+		if !strings.HasPrefix(key, "utm_") && !trackingParams[key] {
+			kept = append(kept, param)
+		}
+	}
+	if len(kept) == len(params) {
+		return s
+	}
+	out := base
+	if len(kept) > 0 {
+		out += "?" + strings.Join(kept, "&")
+	}
+	if hasFragment {
+		out += "#" + fragment
+	}
+	return out
+}
+
+func prettyJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(s), "", "  "); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+func minifyJSON(s string) string {
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, []byte(s)); err != nil {
+		return s
+	}
+	return buf.String()
+}
+
+// shellQuote quotes s as a single POSIX shell word.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sqlQuote quotes s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// jsonQuote encodes s as a JSON string without HTML escaping.
+func jsonQuote(s string) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return s
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// decodeBase64 decodes standard or URL-safe base64, with or without
+// padding, if the result is text.
+func decodeBase64(s string) string {
+	trimmed := strings.TrimSpace(s)
+	for _, enc := range []*base64.Encoding{
+		base64.StdEncoding, base64.RawStdEncoding, base64.URLEncoding, base64.RawURLEncoding,
+	} {
+		if b, err := enc.DecodeString(trimmed); err == nil && utf8.Valid(b) {
+			return string(b)
+		}
+	}
+	return s
+}
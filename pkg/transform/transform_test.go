@@ -0,0 +1,57 @@
+package transform
+
+import "testing"
+
+func TestTransforms(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+	}{
+		{"trim", "  a b \n", "a b"},
+		{"lower", "MiXed", "mixed"},
+		{"upper", "MiXed", "MIXED"},
+		{"url-clean", "https://example.com/p?id=7&utm_source=x&fbclid=y", "https://example.com/p?id=7"},
+		{"url-clean", "https://example.com/p?id=7", "https://example.com/p?id=7"},
+		{"url-clean", "not a url?utm_source=x", "not a url?utm_source=x"},
+		{"url-clean", "https://example.com/s?z=1&utm_medium=m&b=a%2Bb&a=x+y#top", "https://example.com/s?z=1&b=a%2Bb&a=x+y#top"},
+		{"url-clean", "https://example.com/s?utm_%73ource=x&q", "https://example.com/s?q"},
+		{"url-clean", "https://example.com/s?gclid=1#frag", "https://example.com/s#frag"},
+		{"json-pretty", `{"a":[1,2]}`, "{\n  \"a\": [\n    1,\n    2\n  ]\n}"},
+		{"json-pretty", "{broken", "{broken"},
+		{"json-minify", "{\n  \"a\": 1\n}", `{"a":1}`},
+		{"shell-quote", "it's", `'it'\''s'`},
+		{"sql-quote", "O'Brien", "'O''Brien'"},
+		{"json-quote", "a \"b\" <c>\n", `"a \"b\" <c>\n"`},
+		{"base64-encode", "hello", "aGVsbG8="},
+		{"base64-decode", "aGVsbG8=", "hello"},
+		{"base64-decode", "aGVsbG8", "hello"},
+		{"base64-decode", "not base64!", "not base64!"},
+	}
+	for _, tc := range cases {
+		f, ok := Lookup(tc.name)
+		if !ok {
+			t.Fatalf("missing transform %q", tc.name)
+		}
+		if got := f(tc.in); got != tc.want {
+			t.Errorf("%s(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	chain, err := Parse("trim, upper,,sql-quote")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got := chain.Apply("  it's "); got != "'IT''S'" {
+		t.Errorf("wrong chain result %q", got)
+	}
+	if chain.String() != "trim,upper,sql-quote" {
+		t.Errorf("wrong chain string %q", chain.String())
+	}
+	if _, err := Parse("trim,rot13"); err == nil {
+		t.Error("expected error for unknown transform")
+	}
+	if got := Chain(nil).Apply("x"); got != "x" {
+		t.Errorf("empty chain changed the value: %q", got)
+	}
+}
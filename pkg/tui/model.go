@@ -0,0 +1,381 @@
+package tui
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/queue"
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+// model holds what the interface shows and turns key presses into
+// queue.Manager calls. It does no terminal I/O so it can be tested directly.
+type model struct {
+	mgr    *queue.Manager
+	edit   func(text string) (string, error)
+	queues *Queues // nil if the storage holds a single queue
+	queue  string  // name of the queue shown, with queues
+
+	items  []storage.Item
+	active bool
+	mode   storage.Mode
+	next   int // index of the item pasted next, -1 if none
+
+	cursor    int // position within visible()
+	top       int // first visible() row on screen
+	query     string
+	searching bool
+	naming    bool   // typing the name of a queue to switch to
+	name      string // typed so far
+	status    string
+	quit      bool
+}
+
+func newModel(mgr *queue.Manager, edit func(string) (string, error)) *model {
+	return &model{mgr: mgr, edit: edit}
+}
+
+// refresh re-reads the queue from storage so captures by the monitor show up.
+// It keeps the cursor on the same item where possible.
+func (m *model) refresh() error {
+	state, err := m.mgr.Reload()
+	if err != nil {
+		return err
+	}
+	var selectedID string
+	if idx := m.selected(); idx >= 0 {
+		selectedID = m.items[idx].ID
+	}
+	m.items = slices.Clone(state.Items)
+	m.active = state.Active
+	m.mode = state.Mode
+	m.next = queue.NextIndex(state)
+	for pos, idx := range m.visible() {
+		if m.items[idx].ID == selectedID {
+			m.cursor = pos
+			break
+		}
+	}
+	m.clamp()
+	return nil
+}
+
+// visible returns the indices of the items matching the search query.
+func (m *model) visible() []int {
+	out := make([]int, 0, len(m.items))
+	q := strings.ToLower(m.query)
+	for i, item := range m.items {
+		if q == "" || strings.Contains(strings.ToLower(item.Text), q) {
+			out = append(out, i)
+		}
+	}
+	return out
+}
+
+// selected returns the queue index under the cursor, or -1.
+func (m *model) selected() int {
+	vis := m.visible()
+	if m.cursor < 0 || m.cursor >= len(vis) {
+		return -1
+	}
+	return vis[m.cursor]
+}
+
+func (m *model) clamp() {
+	n := len(m.visible())
+	if m.cursor >= n {
+		m.cursor = n - 1
+	}
+	if m.cursor < 0 {
+		m.cursor = 0
+	}
+}
+
+// handle applies a single key press.
+func (m *model) handle(k string) {
+	m.status = ""
+	if m.searching {
+		m.handleSearch(k)
+		return
+	}
+	if m.naming {
+		m.handleName(k)
+		return
+	}
+
+	switch k {
+	case "q", "ctrl+c":
+		m.quit = true
+	case "up", "k":
+		m.cursor--
+	case "down", "j":
+		m.cursor++
+	case "pgup":
+		m.cursor -= 10
+	case "pgdown":
+		m.cursor += 10
+	case "home", "g":
+		m.cursor = 0
+	case "end", "G":
+		m.cursor = len(m.visible()) - 1
+	case "K":
+		m.shift(-1)
+	case "J":
+		m.shift(1)
+	case "d", "delete":
+		m.delete()
+	case "e", "enter":
+		m.editSelected()
+	case "/":
+		m.searching = true
+	case "esc":
+		m.query = ""
+	case "m":
+		m.nextMode()
+	case "tab":
+		m.cycleQueue()
+	case "Q":
+		if m.canSwitch() {
+			m.naming, m.name = true, ""
+		}
+	}
+	m.clamp()
+}
+
+func (m *model) handleSearch(k string) {
+	switch k {
+	case "enter":
+		m.searching = false
+	case "esc", "ctrl+c":
+		m.searching = false
+		m.query = ""
+	case "backspace":
+		if r := []rune(m.query); len(r) > 0 {
+			m.query = string(r[:len(r)-1])
+		}
+	default:
+		if len([]rune(k)) == 1 {
+			m.query += k
+		}
+	}
+	m.cursor = 0
+	m.clamp()
+}
+
+func (m *model) handleName(k string) {
+	switch k {
+	case "enter":
+		m.naming = false
+		m.switchQueue(m.name)
+	case "esc", "ctrl+c":
+		m.naming = false
+	case "backspace":
+		if r := []rune(m.name); len(r) > 0 {
+			m.name = string(r[:len(r)-1])
+		}
+	default:
+		if len([]rune(k)) == 1 {
+			m.name += k
+		}
+	}
+}
+
+// canSwitch reports whether there are queues to switch between, and says
+// why not otherwise.
+func (m *model) canSwitch() bool {
+	if m.queues == nil {
+		m.status = "This storage holds a single queue; switching needs bolt storage (see cbq storage)"
+	}
+	return m.queues != nil
+}
+
+// cycleQueue switches to the queue after the current one, in name order.
+func (m *model) cycleQueue() {
+	if !m.canSwitch() {
+		return
+	}
+	names, err := m.queues.List()
+	if err != nil {
+		m.status = "Error: " + err.Error()
+		return
+	}
+	if !slices.Contains(names, m.queue) {
+		// Not stored yet, e.g. a queue nothing was copied to.
+		names = append(names, m.queue)
+		slices.Sort(names)
+	}
+	if len(names) == 1 {
+		m.status = "No other queues; press Q to start one"
+		return
+	}
+	m.switchQueue(names[(slices.Index(names, m.queue)+1)%len(names)])
+}
+
+// switchQueue shows the named queue, which is created once something is
+// stored in it.
+func (m *model) switchQueue(name string) {
+	name = strings.TrimSpace(name)
+	if name == "" || name == m.queue {
+		return
+	}
+	mgr, err := m.queues.Open(name)
+	if err != nil {
+		m.status = "Error: " + err.Error()
+		return
+	}
+	m.mgr, m.queue = mgr, name
+	m.items, m.cursor, m.top, m.query = nil, 0, 0, ""
+	m.apply(nil)
+	if m.status == "" {
+		m.status = "Switched to queue " + name
+	}
+}
+
+// shift moves the selected item past its visible neighbour in direction dir.
+func (m *model) shift(dir int) {
+	vis := m.visible()
+	pos := m.cursor + dir
+	if m.selected() < 0 || pos < 0 || pos >= len(vis) {
+		return
+	}
+	// By ID: the monitor may have popped items since the last refresh. The
+	// refresh in apply keeps the cursor on the moved item.
+	m.apply(m.mgr.MoveItem(m.items[vis[m.cursor]].ID, m.items[vis[pos]].ID))
+}
+
+func (m *model) delete() {
+	idx := m.selected()
+	if idx < 0 {
+		return
+	}
+	if _, err := m.mgr.DeleteItem(m.items[idx].ID); err != nil {
+		m.apply(err)
+		return
+	}
+	m.apply(nil)
+	m.status = fmt.Sprintf("Deleted item %d", idx)
+}
+
+func (m *model) editSelected() {
+	idx := m.selected()
+	if idx < 0 {
+		return
+	}
+	item := m.items[idx]
+	edited, err := m.edit(item.Text)
+	if err != nil {
+		m.status = err.Error()
+		return
+	}
+	if edited == item.Text {
+		return
+	}
+	m.apply(m.mgr.EditItem(item.ID, item.Text, edited))
+}
+
+func (m *model) nextMode() {
+	_, err := m.mgr.NextMode()
+	m.apply(err)
+}
+
+// apply reports err, if any, and reloads the queue after an edit.
+func (m *model) apply(err error) {
+	if rerr := m.refresh(); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		m.status = "Error: " + err.Error()
+	}
+}
+
+// view renders the screen for a terminal of the given size.
+func (m *model) view(width, height int) []string {
+	mode := m.mode.Label()
+	status := "inactive"
+	if m.active {
+		status = "active"
+	}
+	header := fmt.Sprintf(" cbq · %s · %s · %d items", status, mode, len(m.items))
+	if m.queues != nil {
+		header = fmt.Sprintf(" cbq · %s · %s · %s · %d items", m.queue, status, mode, len(m.items))
+	}
+	if m.query != "" || m.searching {
+		header += fmt.Sprintf(" · filter: %s", m.query)
+	}
+
+	var footer string
+	switch {
+	case m.searching:
+		footer = " /" + m.query + "█   enter keep filter · esc clear"
+	case m.naming:
+		footer = " queue: " + m.name + "█   enter switch · esc cancel"
+	case m.status != "":
+		footer = " " + m.status
+	default:
+		footer = " ↑↓ select · J/K move · e edit · d delete · / search · m mode · q quit"
+		if m.queues != nil {
+			footer = " ↑↓ select · J/K move · e edit · d delete · / search · m mode · tab/Q queue · q quit"
+		}
+	}
+
+	rows := height - 3
+	if rows < 1 {
+		rows = 1
+	}
+	if m.cursor < m.top {
+		m.top = m.cursor
+	}
+	if m.cursor >= m.top+rows {
+		m.top = m.cursor - rows + 1
+	}
+
+	lines := []string{reverse(pad(header, width)), ""}
+	vis := m.visible()
+	for pos := m.top; pos < len(vis) && pos < m.top+rows; pos++ {
+		idx := vis[pos]
+		marker := "  "
+		if idx == m.next {
+			marker = "▶ "
+		}
+		line := pad(fmt.Sprintf(" %s%3d  %s", marker, idx, oneLine(m.items[idx].Text)), width)
+		if pos == m.cursor {
+			line = reverse(line)
+		}
+		lines = append(lines, line)
+	}
+	if len(vis) == 0 {
+		if len(m.items) == 0 {
+			lines = append(lines, "   (queue is empty)")
+		} else {
+			lines = append(lines, "   (no matches)")
+		}
+	}
+	for len(lines) < height-1 {
+		lines = append(lines, "")
+	}
+	return append(lines, pad(footer, width))
+}
+
+// oneLine flattens an item for a single row of the list.
+func oneLine(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "⏎")
+	s = strings.ReplaceAll(s, "\n", "⏎")
+	return strings.ReplaceAll(s, "\t", "  ")
+}
+
+// pad truncates or pads s to exactly width runes.
+func pad(s string, width int) string {
+	r := []rune(s)
+	if len(r) > width {
+		if width < 1 {
+			return ""
+		}
+		return string(r[:width-1]) + "…"
+	}
+	return s + strings.Repeat(" ", width-len(r))
+}
+
+func reverse(s string) string {
+	return "\x1b[7m" + s + "\x1b[0m"
+}
@@ -0,0 +1,194 @@
+package tui
+
+import (
+	"errors"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/queue"
+	"github.com/matouschdavid/Clipboard-queue/pkg/storage"
+)
+
+type fakeClipboard struct{ content string }
+
+func (f *fakeClipboard) Read() (string, error)   { return f.content, nil }
+func (f *fakeClipboard) Write(text string) error { f.content = text; return nil }
+
+// newTestModel returns a model over a real JSON file, plus the storage so
+// tests can play the part of the monitor writing to it.
+func newTestModel(t *testing.T, items ...string) (*model, *storage.JSONStorage) {
+	t.Helper()
+	s := storage.NewJSONStorage(filepath.Join(t.TempDir(), "state.json"))
+	state := &storage.State{Active: true}
+	for _, text := range items {
+		state.Items = append(state.Items, storage.NewItem(text))
+	}
+	if err := s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	m := newModel(queue.NewManager(s, &fakeClipboard{}), func(text string) (string, error) {
+		return strings.ToUpper(text), nil
+	})
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	return m, s
+}
+
+func press(m *model, keys ...string) {
+	for _, k := range keys {
+		m.handle(k)
+	}
+}
+
+func TestModel_ReorderAndDelete(t *testing.T) {
+	m, s := newTestModel(t, "a", "b", "c")
+
+	press(m, "J", "J") // move "a" to the end
+	if !slices.Equal(storage.Texts(m.items), []string{"b", "c", "a"}) {
+		t.Fatalf("wrong order after moving down: %v", m.items)
+	}
+	if m.selected() != 2 {
+		t.Errorf("cursor did not follow the moved item: %d", m.selected())
+	}
+
+	press(m, "K", "d")
+	loaded, _ := s.Load()
+	if !slices.Equal(storage.Texts(loaded.Items), []string{"b", "c"}) {
+		t.Errorf("wrong persisted items: %v", loaded.Items)
+	}
+}
+
+func TestModel_DeleteAfterPop(t *testing.T) {
+	m, s := newTestModel(t, "a", "b", "c")
+	press(m, "down") // select "b"
+
+	// The monitor, in its own process, pastes "a" before the next refresh.
+	monitor := storage.NewJSONStorage(s.Path)
+	state, _ := monitor.Load()
+	state.Items = state.Items[1:]
+	if err := monitor.Save(state); err != nil {
+		t.Fatal(err)
+	}
+
+	press(m, "d")
+	loaded, _ := s.Load()
+	if !slices.Equal(storage.Texts(loaded.Items), []string{"c"}) {
+		t.Errorf("expected the selected item deleted, got %v", loaded.Items)
+	}
+}
+
+func TestModel_Edit(t *testing.T) {
+	m, s := newTestModel(t, "a", "b")
+
+	press(m, "down", "e")
+	loaded, _ := s.Load()
+	if !slices.Equal(storage.Texts(loaded.Items), []string{"a", "B"}) {
+		t.Errorf("edit not saved: %v", loaded.Items)
+	}
+
+	m.edit = func(string) (string, error) { return "", errors.New("editor failed") }
+	press(m, "e")
+	if m.status != "editor failed" {
+		t.Errorf("expected editor error in status, got %q", m.status)
+	}
+}
+
+func TestModel_Search(t *testing.T) {
+	m, _ := newTestModel(t, "apple", "banana", "cherry", "grape")
+
+	press(m, "/", "a", "p", "enter")
+	if got := m.visible(); !slices.Equal(got, []int{0, 3}) {
+		t.Fatalf("wrong matches: %v", got)
+	}
+	press(m, "down", "d")
+	if !slices.Equal(storage.Texts(m.items), []string{"apple", "banana", "cherry"}) {
+		t.Errorf("deleted the wrong item: %v", m.items)
+	}
+
+	press(m, "esc")
+	if len(m.visible()) != 3 {
+		t.Errorf("esc did not clear the filter")
+	}
+}
+
+func TestModel_LiveRefresh(t *testing.T) {
+	m, s := newTestModel(t, "a")
+
+	// Another process captures an item.
+	state, _ := s.Load()
+	state.Items = append(state.Items, storage.NewItem("b"))
+	if err := s.Save(state); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.items) != 2 {
+		t.Errorf("refresh missed external change: %v", m.items)
+	}
+
+	lines := m.view(40, 10)
+	if len(lines) != 10 || !strings.Contains(lines[0], "2 items") {
+		t.Errorf("unexpected view: %q", lines)
+	}
+}
+
+func TestModel_SwitchQueues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.db")
+	for name, text := range map[string]string{"default": "a", "work": "w"} {
+		if err := storage.NewBoltStorage(path, name).Save(&storage.State{Items: []storage.Item{storage.NewItem(text)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open := func(name string) (*queue.Manager, error) {
+		return queue.NewManager(storage.NewBoltStorage(path, name), &fakeClipboard{}), nil
+	}
+	mgr, _ := open("default")
+	m := newModel(mgr, nil)
+	m.queues = &Queues{Current: "default", List: storage.NewBoltStorage(path, "").Queues, Open: open}
+	m.queue = "default"
+	if err := m.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	press(m, "tab")
+	if m.queue != "work" || !slices.Equal(storage.Texts(m.items), []string{"w"}) {
+		t.Fatalf("expected the work queue after tab, got %s: %v", m.queue, m.items)
+	}
+	press(m, "d")
+
+	press(m, "Q", "n", "e", "w", "enter")
+	if m.queue != "new" || len(m.items) != 0 {
+		t.Fatalf("expected the empty new queue, got %s: %v", m.queue, m.items)
+	}
+	press(m, "tab", "tab") // past work, which the delete left empty
+	if m.queue != "default" || !slices.Equal(storage.Texts(m.items), []string{"a"}) {
+		t.Errorf("expected to wrap around to the default queue, got %s: %v", m.queue, m.items)
+	}
+	if loaded, _ := storage.NewBoltStorage(path, "work").Load(); len(loaded.Items) != 0 {
+		t.Errorf("expected the delete to reach the work queue, got %v", loaded.Items)
+	}
+}
+
+func TestModel_SwitchQueuesUnsupported(t *testing.T) {
+	m, _ := newTestModel(t, "a")
+	press(m, "tab")
+	if !strings.Contains(m.status, "single queue") {
+		t.Errorf("expected switching to be refused, got status %q", m.status)
+	}
+	press(m, "Q")
+	if m.naming {
+		t.Error("expected no queue prompt without queues to switch between")
+	}
+}
+
+func TestDecodeKeys(t *testing.T) {
+	got := decodeKeys([]byte("j\x1b[A\x1b\r\x7f\té"))
+	want := []string{"j", "up", "esc", "enter", "backspace", "tab", "é"}
+	if !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
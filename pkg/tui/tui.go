@@ -0,0 +1,205 @@
+// Package tui is a full-screen terminal interface for browsing and editing
+// the queue. It re-reads the queue periodically so items captured by the
+// monitor appear while it is open.
+package tui
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"github.com/matouschdavid/Clipboard-queue/pkg/editor"
+	"github.com/matouschdavid/Clipboard-queue/pkg/queue"
+)
+
+// refreshInterval is how often the queue is re-read from storage.
+const refreshInterval = 500 * time.Millisecond
+
+// Queues lets the interface switch between the named queues of a storage.
+type Queues struct {
+	// Current names the queue the Manager passed to Run works on.
+	Current string
+	// List returns the names of the stored queues.
+	List func() ([]string, error)
+	// Open returns a Manager for the named queue, which need not exist yet.
+	Open func(name string) (*queue.Manager, error)
+}
+
+// Run takes over the terminal until the user quits. queues may be nil if
+// the storage holds a single queue.
+func Run(mgr *queue.Manager, queues *Queues) error {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return errors.New("tui needs an interactive terminal")
+	}
+
+	t := &terminal{fd: fd, out: os.Stdout}
+	if err := t.enter(); err != nil {
+		return err
+	}
+	defer t.leave()
+
+	m := newModel(mgr, func(text string) (string, error) {
+		// Hand the terminal to the editor while it runs.
+		t.leave()
+		defer t.enter()
+		return editor.Edit(text)
+	})
+	if queues != nil {
+		m.queues, m.queue = queues, queues.Current
+	}
+	if err := m.refresh(); err != nil {
+		return err
+	}
+
+	keys := make(chan []string)
+	resume := make(chan struct{})
+	go readKeys(os.Stdin, keys, resume)
+
+	resize := make(chan os.Signal, 1)
+	signal.Notify(resize, syscall.SIGWINCH)
+	defer signal.Stop(resize)
+
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for !m.quit {
+		t.draw(m)
+		select {
+		case batch, ok := <-keys:
+			if !ok {
+				return nil
+			}
+			for _, k := range batch {
+				m.handle(k)
+			}
+			if !m.quit {
+				resume <- struct{}{}
+			}
+		case <-ticker.C:
+			if err := m.refresh(); err != nil {
+				m.status = "Error: " + err.Error()
+			}
+		case <-resize:
+		}
+	}
+	return nil
+}
+
+type terminal struct {
+	fd    int
+	out   io.Writer
+	saved *term.State
+}
+
+// enter switches to raw mode on the alternate screen.
+func (t *terminal) enter() error {
+	saved, err := term.MakeRaw(t.fd)
+	if err != nil {
+		return err
+	}
+	t.saved = saved
+	fmt.Fprint(t.out, "\x1b[?1049h\x1b[?25l")
+	return nil
+}
+
+// leave restores the terminal as it was before enter.
+func (t *terminal) leave() {
+	if t.saved == nil {
+		return
+	}
+	fmt.Fprint(t.out, "\x1b[?25h\x1b[?1049l")
+	_ = term.Restore(t.fd, t.saved)
+	t.saved = nil
+}
+
+func (t *terminal) draw(m *model) {
+	width, height, err := term.GetSize(t.fd)
+	if err != nil || width <= 0 || height <= 0 {
+		width, height = 80, 24
+	}
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range m.view(width, height) {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\x1b[K")
+	}
+	io.WriteString(t.out, b.String())
+}
+
+// readKeys decodes raw terminal input into key names until r fails. After
+// each batch it waits on resume, so it is not competing for input while the
+// keys are handled, which may involve running an editor on the terminal.
+func readKeys(r io.Reader, out chan<- []string, resume <-chan struct{}) {
+	defer close(out)
+	buf := make([]byte, 256)
+	for {
+		n, err := r.Read(buf)
+		if err != nil {
+			return
+		}
+		out <- decodeKeys(buf[:n])
+		<-resume
+	}
+}
+
+var escapes = map[string]string{
+	"\x1b[A": "up", "\x1b[B": "down", "\x1b[C": "right", "\x1b[D": "left",
+	"\x1bOA": "up", "\x1bOB": "down", "\x1bOC": "right", "\x1bOD": "left",
+	"\x1b[H": "home", "\x1b[F": "end", "\x1b[1~": "home", "\x1b[4~": "end",
+	"\x1b[5~": "pgup", "\x1b[6~": "pgdown", "\x1b[3~": "delete",
+}
+
+// decodeKeys splits one read of terminal input into key names. Printable
+// characters are returned as themselves.
+func decodeKeys(b []byte) []string {
+	var keys []string
+	for len(b) > 0 {
+		if b[0] == 0x1b {
+			matched := false
+			for seq, name := range escapes {
+				if strings.HasPrefix(string(b), seq) {
+					keys = append(keys, name)
+					b = b[len(seq):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				keys = append(keys, "esc")
+				b = b[1:]
+			}
+			continue
+		}
+		switch b[0] {
+		case '\r', '\n':
+			keys = append(keys, "enter")
+		case '\t':
+			keys = append(keys, "tab")
+		case 0x7f, 0x08:
+			keys = append(keys, "backspace")
+		case 0x03:
+			keys = append(keys, "ctrl+c")
+		default:
+			r, size := utf8.DecodeRune(b)
+			if r >= ' ' {
+				keys = append(keys, string(r))
+			}
+			b = b[size:]
+			continue
+		}
+		b = b[1:]
+	}
+	return keys
+}